@@ -24,3 +24,8 @@ func (n *CIDRNet) UnmarshalFlag(value string) error {
 func (n CIDRNet) MarshalFlag() (string, error) {
 	return n.IPNet.String(), nil
 }
+
+// overlaps returns true if the two networks have any addresses in common.
+func (n CIDRNet) overlaps(other CIDRNet) bool {
+	return n.Contains(other.IP) || other.Contains(n.IP)
+}
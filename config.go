@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configJob is a job section in a config file.  The keys match the ones used
+// by --job.
+type configJob struct {
+	File     string   `yaml:"file"`
+	Domain   string   `yaml:"domain"`
+	Zones    []string `yaml:"zone"`
+	Networks []string `yaml:"network"`
+	TTL      int64    `yaml:"ttl"`
+	Excludes []string `yaml:"exclude"`
+	Interval string   `yaml:"interval"`
+}
+
+// spec returns the job in the form used by --job.
+func (j configJob) spec() string {
+	settings := []string{}
+	add := func(key string, value interface{}) {
+		settings = append(settings, fmt.Sprintf("%v=%v", key, value))
+	}
+
+	if j.File != "" {
+		add("file", j.File)
+	}
+	if j.Domain != "" {
+		add("domain", j.Domain)
+	}
+	for _, z := range j.Zones {
+		add("zone", z)
+	}
+	for _, n := range j.Networks {
+		add("network", n)
+	}
+	if j.TTL != 0 {
+		add("ttl", j.TTL)
+	}
+	for _, e := range j.Excludes {
+		add("exclude", e)
+	}
+	if j.Interval != "" {
+		add("interval", j.Interval)
+	}
+
+	return strings.Join(settings, ",")
+}
+
+// withConfigFile returns the command line arguments with the settings from
+// the --config file, if one was given, added in front of them.  Options given
+// on the command line take precedence over the config file, including ones
+// that can be given more than once.
+func withConfigFile(args []string) ([]string, error) {
+	var cli options
+	parser := flags.NewParser(&cli, flags.None)
+	if _, err := parser.ParseArgs(args); err != nil || cli.Config == "" {
+		// Any errors are reported when the arguments are parsed for real
+		return args, nil
+	}
+
+	data, err := ioutil.ReadFile(cli.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read config file")
+	}
+
+	configArgs, err := parseConfig(data, parser)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid config file %v", cli.Config)
+	}
+
+	return append(configArgs, args...), nil
+}
+
+// parseConfig converts a YAML config file into command line arguments.  The
+// top level keys are the long option names, and a "jobs" list holds one
+// section per --job.  Options already set in parser are skipped.
+func parseConfig(data []byte, parser *flags.Parser) ([]string, error) {
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	// Sorted so that errors are always reported in the same order
+	sort.Strings(keys)
+
+	args := []string{}
+	for _, key := range keys {
+		value := config[key]
+		if key == "jobs" {
+			if setOnCommandLine(parser.FindOptionByLongName("job")) {
+				continue
+			}
+			jobs, err := parseConfigJobs(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, job := range jobs {
+				args = append(args, "--job="+job.spec())
+			}
+			continue
+		}
+
+		opt := parser.FindOptionByLongName(key)
+		if opt == nil || key == "config" {
+			return nil, fmt.Errorf("unknown option '%v'", key)
+		}
+		if setOnCommandLine(opt) {
+			continue
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if b, ok := v.(bool); ok {
+				if _, isBool := opt.Value().(bool); isBool {
+					if b {
+						args = append(args, "--"+key)
+					}
+					continue
+				}
+			}
+			args = append(args, fmt.Sprintf("--%v=%v", key, v))
+		}
+	}
+
+	return args, nil
+}
+
+// setOnCommandLine returns true if opt was given a value by the parser, other
+// than its default.
+func setOnCommandLine(opt *flags.Option) bool {
+	return opt.IsSet() && !opt.IsSetDefault()
+}
+
+// parseConfigJobs decodes the jobs section of a config file.
+func parseConfigJobs(value interface{}) ([]configJob, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []configJob{}
+	if err := yaml.UnmarshalStrict(data, &jobs); err != nil {
+		return nil, errors.Wrap(err, "Invalid jobs section")
+	}
+
+	return jobs, nil
+}
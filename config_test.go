@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+domain: test.com
+ttl: 300
+network:
+  - 10.0.0.0/8
+  - 192.168.0.0/16
+alias-cnames: true
+multi-value: false
+jobs:
+  - file: /etc/hosts.dmz
+    domain: dmz.test.com
+    zone: [servers.test.com]
+    network: [192.168.10.0/24]
+    exclude: [gw.dmz.test.com]
+    interval: 5m
+`
+
+func TestParseConfig(t *testing.T) {
+	var cli options
+	parser := flags.NewParser(&cli, flags.None)
+	_, err := parser.ParseArgs([]string{"--ttl=60"})
+	assert.Nil(t, err)
+
+	args, err := parseConfig([]byte(testConfig), parser)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"--alias-cnames",
+		"--domain=test.com",
+		"--job=file=/etc/hosts.dmz,domain=dmz.test.com,zone=servers.test.com,network=192.168.10.0/24,exclude=gw.dmz.test.com,interval=5m",
+		"--network=10.0.0.0/8",
+		"--network=192.168.0.0/16",
+	}, args)
+
+	// Options given on the command line replace lists from the config
+	cli = options{}
+	parser = flags.NewParser(&cli, flags.None)
+	_, err = parser.ParseArgs([]string{"--network=172.16.0.0/12", "--job=domain=test.com"})
+	assert.Nil(t, err)
+
+	args, err = parseConfig([]byte(testConfig), parser)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"--alias-cnames",
+		"--domain=test.com",
+		"--ttl=300",
+	}, args)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"colour: blue",
+		"config: other.yaml",
+		"jobs:\n  - colour: blue",
+		"jobs: [",
+	} {
+		var cli options
+		parser := flags.NewParser(&cli, flags.None)
+		_, err := parser.ParseArgs([]string{})
+		assert.Nil(t, err)
+
+		_, err = parseConfig([]byte(config), parser)
+		assert.NotNil(t, err, config)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	pauseMu sync.Mutex
+	paused  bool
+	// pauseTimer resumes syncing at the end of a timed pause
+	pauseTimer *time.Timer
+	pauseUntil time.Time
+)
+
+// pauseSyncing stops the daemon from syncing until resumeSyncing is called,
+// or until d has passed if it isn't zero.  The daemon keeps watching the
+// input files while paused.
+func pauseSyncing(d time.Duration) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	if pauseTimer != nil {
+		pauseTimer.Stop()
+		pauseTimer = nil
+	}
+	paused = true
+	pauseUntil = time.Time{}
+	if d > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(d, func() {
+			// A timer that was replaced may fire before it is stopped
+			pauseMu.Lock()
+			current := pauseTimer == timer
+			pauseMu.Unlock()
+			if current {
+				resumeSyncing()
+			}
+		})
+		pauseTimer = timer
+		pauseUntil = time.Now().Add(d)
+	}
+}
+
+// resumeSyncing undoes pauseSyncing, and syncs every job straight away to
+// pick up anything that changed in the meantime.
+func resumeSyncing() {
+	pauseMu.Lock()
+	wasPaused := paused
+	if pauseTimer != nil {
+		pauseTimer.Stop()
+		pauseTimer = nil
+	}
+	paused = false
+	pauseUntil = time.Time{}
+	pauseMu.Unlock()
+
+	if wasPaused {
+		log.Info("Syncing resumed")
+		requestResync(runningJobs())
+	}
+}
+
+// syncingPaused returns true if syncing is paused.
+func syncingPaused() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return paused
+}
+
+// pauseState is the JSON body returned by /pause and /resume.
+type pauseState struct {
+	Paused bool       `json:"paused"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+func currentPauseState() pauseState {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return pauseState{Paused: paused, Until: timePtr(pauseUntil)}
+}
+
+// jobPlan is a job's entry in the /plan response.
+type jobPlan struct {
+	Job      string     `json:"job"`
+	Computed time.Time  `json:"computed"`
+	Zones    []planZone `json:"zones"`
+}
+
+// jobRecords is a job's entry in the /records response.
+type jobRecords struct {
+	Job     string       `json:"job"`
+	Records []planRecord `json:"records"`
+}
+
+// writeJSON writes v to w as the response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("Could not write control API response: ", err)
+	}
+}
+
+// controlError writes an error response in the same JSON form as the rest of
+// the control API.
+func controlError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// selectJobs returns the running jobs matching the job query parameter,
+// which can be a domain, an input file or a job name as shown in the logs.
+// Every job is returned if the parameter isn't given.
+func selectJobs(r *http.Request) []syncJob {
+	jobs := runningJobs()
+	name := r.URL.Query().Get("job")
+	if name == "" {
+		return jobs
+	}
+
+	selected := []syncJob{}
+	for _, job := range jobs {
+		if name == job.domain || name == job.file || name == job.String() {
+			selected = append(selected, job)
+		}
+	}
+	return selected
+}
+
+// controlHandler returns the handler for the control API.
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			controlError(w, http.StatusMethodNotAllowed, "use POST to trigger a sync")
+			return
+		}
+		// The sync would only be skipped
+		if syncingPaused() {
+			controlError(w, http.StatusConflict, "syncing is paused, resume it first")
+			return
+		}
+		jobs := selectJobs(r)
+		if len(jobs) == 0 {
+			controlError(w, http.StatusNotFound, "no job matches '%v'", r.URL.Query().Get("job"))
+			return
+		}
+
+		names := []string{}
+		for _, job := range jobs {
+			names = append(names, job.String())
+		}
+		log.Info("Sync requested through the control API for ", names)
+		requestResync(jobs)
+		writeJSON(w, map[string][]string{"syncing": names})
+	})
+
+	mux.HandleFunc("/plan", func(w http.ResponseWriter, r *http.Request) {
+		plans := []jobPlan{}
+		for _, job := range selectJobs(r) {
+			status, ok := jobStatus(job)
+			if !ok {
+				continue
+			}
+			jp := jobPlan{Job: job.String(), Computed: status.started, Zones: []planZone{}}
+			for _, p := range status.plans {
+				jp.Zones = append(jp.Zones, newPlanZone(p))
+			}
+			plans = append(plans, jp)
+		}
+		writeJSON(w, plans)
+	})
+
+	mux.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+		records := []jobRecords{}
+		for _, job := range selectJobs(r) {
+			status, ok := jobStatus(job)
+			if !ok {
+				continue
+			}
+			records = append(records, jobRecords{
+				Job:     job.String(),
+				Records: newPlanRecords(status.records()),
+			})
+		}
+		writeJSON(w, records)
+	})
+
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			controlError(w, http.StatusMethodNotAllowed, "use POST to pause syncing")
+			return
+		}
+
+		var d time.Duration
+		if v := r.URL.Query().Get("for"); v != "" {
+			var err error
+			d, err = time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				controlError(w, http.StatusBadRequest, "invalid duration '%v'", v)
+				return
+			}
+		}
+
+		pauseSyncing(d)
+		if d > 0 {
+			log.Info("Syncing paused through the control API for ", d)
+		} else {
+			log.Info("Syncing paused through the control API")
+		}
+		writeJSON(w, currentPauseState())
+	})
+
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			controlError(w, http.StatusMethodNotAllowed, "use POST to resume syncing")
+			return
+		}
+		resumeSyncing()
+		writeJSON(w, currentPauseState())
+	})
+
+	return mux
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncStatusRecords(t *testing.T) {
+	status := syncStatus{
+		plans: syncPlans{
+			{
+				domain: "records.test.com",
+				toUpdate: hostList{
+					{hostname: "test1.records.test.com", ip: net.ParseIP("1.2.3.4")},
+					{hostname: "test2.records.test.com", ip: net.ParseIP("1.2.3.7")},
+				},
+				toDelete: hostList{{hostname: "test3.records.test.com", ip: net.ParseIP("1.2.3.6")}},
+				current: hostList{
+					{hostname: "test2.records.test.com", ip: net.ParseIP("1.2.3.5")},
+					{hostname: "test3.records.test.com", ip: net.ParseIP("1.2.3.6")},
+					{hostname: "test4.records.test.com", ip: net.ParseIP("1.2.3.8")},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, []planRecord{
+		{Name: "test1.records.test.com", Type: "A", Value: "1.2.3.4"},
+		{Name: "test2.records.test.com", Type: "A", Value: "1.2.3.7"},
+		{Name: "test4.records.test.com", Type: "A", Value: "1.2.3.8"},
+	}, newPlanRecords(status.records()))
+
+	// A failed sync leaves the records as they were
+	status.err = errors.New("rate exceeded")
+	assert.Equal(t, status.plans[0].current, status.records())
+}
+
+func TestPauseSyncing(t *testing.T) {
+	defer resumeSyncing()
+	assert.False(t, syncingPaused())
+
+	pauseSyncing(0)
+	assert.True(t, syncingPaused())
+	assert.Equal(t, pauseState{Paused: true}, currentPauseState())
+
+	resumeSyncing()
+	assert.False(t, syncingPaused())
+
+	// A timed pause resumes by itself, unless it is replaced
+	pauseSyncing(10 * time.Millisecond)
+	assert.NotNil(t, currentPauseState().Until)
+	pauseSyncing(0)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, syncingPaused())
+
+	pauseSyncing(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, syncingPaused())
+}
+
+func TestControlHandler(t *testing.T) {
+	job := syncJob{file: "/etc/hosts.control", domain: "control.test.com"}
+	other := syncJob{file: "/etc/hosts.other", domain: "other.test.com"}
+	resync := make(chan struct{}, 1)
+	setDaemonJobs([]syncJob{job, other}, []chan struct{}{resync, make(chan struct{}, 1)})
+	defer setDaemonJobs(nil, nil)
+	defer resumeSyncing()
+
+	started := time.Unix(1564500000, 0).UTC()
+	recordStatus(job, syncStatus{
+		started: started,
+		plans: syncPlans{
+			{
+				domain:   "control.test.com",
+				toUpdate: hostList{{hostname: "test1.control.test.com", ip: net.ParseIP("1.2.3.4")}},
+			},
+		},
+	})
+
+	handler := controlHandler()
+	request := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := request("GET", "/sync")
+	assert.Equal(t, 405, w.Code)
+
+	w = request("POST", "/sync?job=nothing.test.com")
+	assert.Equal(t, 404, w.Code)
+
+	w = request("POST", "/sync?job=control.test.com")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"syncing":["control.test.com (/etc/hosts.control)"]}`, w.Body.String())
+	assert.Len(t, resync, 1)
+
+	w = request("GET", "/plan?job=/etc/hosts.control")
+	assert.Equal(t, 200, w.Code)
+	plans := []jobPlan{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &plans))
+	assert.Equal(t, []jobPlan{
+		{
+			Job:      "control.test.com (/etc/hosts.control)",
+			Computed: started,
+			Zones: []planZone{
+				{
+					Domain:  "control.test.com",
+					Update:  []planRecord{{Name: "test1.control.test.com", Type: "A", Value: "1.2.3.4"}},
+					Delete:  []planRecord{},
+					Current: []planRecord{},
+					Zone:    []planRecord{},
+				},
+			},
+		},
+	}, plans)
+
+	// Jobs that haven't synced yet are left out
+	w = request("GET", "/records")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `[{"job":"control.test.com (/etc/hosts.control)",
+		"records":[{"name":"test1.control.test.com","type":"A","value":"1.2.3.4"}]}]`, w.Body.String())
+
+	w = request("POST", "/pause?for=soon")
+	assert.Equal(t, 400, w.Code)
+	assert.False(t, syncingPaused())
+
+	w = request("POST", "/pause")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"paused":true}`, w.Body.String())
+	assert.True(t, syncingPaused())
+
+	<-resync
+	w = request("POST", "/sync")
+	assert.Equal(t, 409, w.Code)
+	assert.Len(t, resync, 0)
+
+	w = request("POST", "/resume")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"paused":false}`, w.Body.String())
+	assert.False(t, syncingPaused())
+}
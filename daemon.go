@@ -2,72 +2,220 @@ package main
 
 import (
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/Sirupsen/logrus"
-	"github.com/rjeczalik/notify"
+	flags "github.com/jessevdk/go-flags"
 )
 
-func setupNotify(filename string) (chan notify.EventInfo, string) {
-	absfn, err := filepath.Abs(filename)
-	if err != nil {
-		log.Fatalf("Cannot convert %v to absolute path: %v", filename, err)
+func runIfInputExists(job syncJob) {
+	if syncingPaused() {
+		log.Info("Syncing is paused, skipping sync of ", job)
+	} else if _, err := os.Stat(job.file); err != nil {
+		log.Errorf("Cannot stat %v, skipping sync of %v: %v", job.file, job.domain, err)
+	} else {
+		// Ignore errors here, since we want to keep retrying over and over.
+		runOnce(job)
 	}
+}
 
-	dir := filepath.Dir(absfn)
-	basename := filepath.Base(absfn)
+// daemon runs every job at the same time, each with its own watch and
+// schedule, until the process is killed.  On SIGHUP the configuration is
+// reloaded and the jobs are restarted with it, which also syncs them straight
+// away.  SIGUSR1 forces every job to sync now, and SIGUSR2 logs a status
+// report.
+func daemon() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
 
-	cn := make(chan notify.EventInfo, 1)
-	err = notify.Watch(dir, cn, notify.All)
-	if err != nil {
-		log.Fatal("Cannot setup watch for ", dir, ": ", err)
+	setHealthIntervals(opts.HealthIntervals)
+	if opts.HTTPListen != "" {
+		if err := startHTTPServer(opts.HTTPListen); err != nil {
+			log.Fatal("Cannot start HTTP server: ", err)
+		}
+	}
+	if opts.ControlListen != "" {
+		if err := startControlServer(opts.ControlListen); err != nil {
+			log.Fatal("Cannot start control API: ", err)
+		}
 	}
 
-	log.Infof("Watching %v for changes to %v", dir, basename)
+	for {
+		stop := make(chan struct{})
+		resyncs := make([]chan struct{}, 0, len(syncJobs))
+		for range syncJobs {
+			resyncs = append(resyncs, make(chan struct{}, 1))
+		}
+		setDaemonJobs(syncJobs, resyncs)
+
+		var wg sync.WaitGroup
+		for i, job := range syncJobs {
+			wg.Add(1)
+			go func(job syncJob, resync chan struct{}) {
+				defer wg.Done()
+				runJob(job, stop, resync)
+			}(job, resyncs[i])
+		}
+
+		o, jobs := handleSignals(signals)
 
-	return cn, absfn
+		// Let any sync in progress finish before switching over
+		close(stop)
+		wg.Wait()
+		opts, syncJobs = o, jobs
+		if err := configureLogging(); err != nil {
+			log.Error("Cannot apply the new logging options, keeping the current ones: ", err)
+		}
+		setHealthIntervals(opts.HealthIntervals)
+		log.Info("Configuration reloaded")
+	}
 }
 
-func runIfInputExists(filename string) {
-	if _, err := os.Stat(filename); err != nil {
-		log.Error("Cannot stat hosts file, skipping sync: ", err)
-	} else {
-		// Ignore errors here, since we want to keep retrying over and over.
-		runOnce()
+// handleSignals deals with signals until a SIGHUP brings a valid new
+// configuration, which is returned.  Invalid configurations are logged and
+// ignored.
+func handleSignals(signals chan os.Signal) (options, []syncJob) {
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGUSR1:
+			log.Info("SIGUSR1 received, forcing a resync")
+			requestResync(runningJobs())
+			continue
+		case syscall.SIGUSR2:
+			logStatus(syncJobs)
+			continue
+		}
+
+		log.Info("SIGHUP received, reloading configuration")
+		o, jobs, err := loadOpts(os.Args[1:], flags.None)
+		if err == nil {
+			err = checkWatchable(jobs)
+		}
+		if err != nil {
+			log.Error("Invalid configuration, keeping the current one: ", err)
+			continue
+		}
+		if o.Mode != opts.Mode {
+			log.Warnf("Mode cannot be changed by a reload, staying in %v mode", opts.Mode)
+			o.Mode = opts.Mode
+		}
+		if o.HTTPListen != opts.HTTPListen || o.ControlListen != opts.ControlListen {
+			log.Warn("HTTP listen addresses cannot be changed by a reload, restart to use the new ones")
+			o.HTTPListen, o.ControlListen = opts.HTTPListen, opts.ControlListen
+		}
+		return o, jobs
 	}
+
+	// signals is never closed
+	return opts, syncJobs
 }
 
-func daemon(interval time.Duration, filename string) {
-	cn, absfn := setupNotify(filename)
-	defer notify.Stop(cn)
+// checkWatchable makes sure the directory holding each job's input file
+// exists, since a watch can't be set up otherwise.
+func checkWatchable(jobs []syncJob) error {
+	for _, job := range jobs {
+		absfn, err := filepath.Abs(job.file)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Dir(absfn)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runJob keeps a single job in sync, whenever its input file changes, on its
+// interval and whenever resync is signalled, until stop is closed.
+func runJob(job syncJob, stop chan struct{}, resync chan struct{}) {
+	w := newWatcher(job.file)
+	defer w.stop()
 
-	log.Info("Running initial sync")
-	runIfInputExists(absfn)
+	recordHeartbeat(job, time.Now())
+	log.Info("Running initial sync for ", job)
+	runIfInputExists(job)
 
-	log.Info("sync scheduled every ", interval)
-	ticker := time.NewTicker(interval)
+	log.Infof("sync of %v scheduled every %v", job, job.interval)
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
 
+	changes := &debouncer{quiet: opts.Debounce, maxDelay: opts.DebounceMax}
 	for {
+		recordHeartbeat(job, time.Now())
 		resyncNeeded := false
-		// Block on either the ticker or inotify
+		// Block on either the ticker or the watcher
 		select {
+		case <-stop:
+			return
+		case <-resync:
+			resyncNeeded = true
 		case <-ticker.C:
 			resyncNeeded = true
-		case ei := <-cn:
-			if ei.Path() == absfn {
-				log.Info("file change event detected: ", ei)
-				resyncNeeded = true
-			} else if log.Level <= logrus.DebugLevel {
-				// logrus levels are numerically the opposite of what you'd
-				// expect.  This logs if debug logging is enabled, but not
-				// otherwise.
-				log.Debug("file change event detected: ", ei)
-			}
+		case <-changes.timer(time.Now()):
+			resyncNeeded = true
+		case change := <-w.changes():
+			log.Info("file change event detected: ", change)
+			fileEvents.inc(job.file)
+			changes.event(time.Now())
 		}
 
 		if resyncNeeded {
-			runIfInputExists(absfn)
+			// The sync picks up any file changes still waiting
+			changes.reset()
+			runIfInputExists(job)
 		}
 	}
 }
+
+// debouncer coalesces bursts of file change events into a single sync.  The
+// sync happens once no events have been seen for the quiet period, or once
+// maxDelay has passed since the first event, so that a file that never stops
+// changing is still synced.
+type debouncer struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	first    time.Time
+	last     time.Time
+}
+
+// event records a change seen at now.
+func (d *debouncer) event(now time.Time) {
+	if d.first.IsZero() {
+		d.first = now
+	}
+	d.last = now
+}
+
+// deadline returns when the changes seen so far should be synced, and false
+// if there aren't any.
+func (d *debouncer) deadline() (time.Time, bool) {
+	if d.first.IsZero() {
+		return time.Time{}, false
+	}
+
+	deadline := d.last.Add(d.quiet)
+	if latest := d.first.Add(d.maxDelay); d.maxDelay > 0 && latest.Before(deadline) {
+		deadline = latest
+	}
+	return deadline, true
+}
+
+// timer returns a channel that fires at the deadline.  If there are no
+// changes waiting the channel is nil, so it never fires.
+func (d *debouncer) timer(now time.Time) <-chan time.Time {
+	deadline, ok := d.deadline()
+	if !ok {
+		return nil
+	}
+	return time.After(deadline.Sub(now))
+}
+
+// reset forgets the changes seen so far.
+func (d *debouncer) reset() {
+	d.first = time.Time{}
+	d.last = time.Time{}
+}
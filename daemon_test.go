@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebouncer(t *testing.T) {
+	start := time.Unix(1564500000, 0)
+	d := &debouncer{quiet: 2 * time.Second, maxDelay: 5 * time.Second}
+
+	_, ok := d.deadline()
+	assert.False(t, ok)
+	assert.Nil(t, d.timer(start))
+
+	// Each event pushes the deadline back by the quiet period
+	d.event(start)
+	deadline, ok := d.deadline()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(2*time.Second), deadline)
+
+	d.event(start.Add(time.Second))
+	deadline, _ = d.deadline()
+	assert.Equal(t, start.Add(3*time.Second), deadline)
+
+	// ...but never past the maximum delay from the first event
+	d.event(start.Add(4 * time.Second))
+	deadline, _ = d.deadline()
+	assert.Equal(t, start.Add(5*time.Second), deadline)
+
+	d.reset()
+	_, ok = d.deadline()
+	assert.False(t, ok)
+
+	// Without a maximum delay only the quiet period matters
+	d = &debouncer{quiet: 2 * time.Second}
+	d.event(start)
+	d.event(start.Add(10 * time.Second))
+	deadline, _ = d.deadline()
+	assert.Equal(t, start.Add(12*time.Second), deadline)
+}
+
+func TestDebouncerTimer(t *testing.T) {
+	d := &debouncer{}
+	now := time.Now()
+	d.event(now)
+
+	select {
+	case <-d.timer(now):
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
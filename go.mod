@@ -7,9 +7,11 @@ require (
 	github.com/aws/aws-sdk-go v1.21.6
 	github.com/davecgh/go-spew v0.0.0-20161028175848-04cdfd42973b // indirect
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/miekg/dns v1.1.15
 	github.com/pkg/errors v0.8.1
 	github.com/pmezard/go-difflib v0.0.0-20151028094244-d8ed2627bdf0 // indirect
 	github.com/rjeczalik/notify v0.9.2
 	github.com/stretchr/testify v0.0.0-20170530201152-e964b172ca7f
 	golang.org/x/net v0.0.0-20190628185345-da137c7871d7 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
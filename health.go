@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jobHealth is the health of a single job, as reported by /healthz and
+// /readyz.
+type jobHealth struct {
+	Job         string     `json:"job"`
+	OK          bool       `json:"ok"`
+	Heartbeat   *time.Time `json:"heartbeat,omitempty"`
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// healthReport is the JSON body of /healthz and /readyz.
+type healthReport struct {
+	OK   bool        `json:"ok"`
+	Jobs []jobHealth `json:"jobs"`
+}
+
+// timePtr returns nil for the zero time, so that it's left out of the JSON.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// checkHealth reports on each job at now.  When ready is false a job is
+// healthy as long as its loop has run within the last limit intervals.  When
+// ready is true a job also needs to have synced successfully within the last
+// limit intervals.
+func checkHealth(jobs []syncJob, now time.Time, limit int, ready bool) healthReport {
+	report := healthReport{OK: true, Jobs: []jobHealth{}}
+	for _, job := range jobs {
+		maxAge := time.Duration(limit) * job.interval
+		health := jobHealth{Job: job.String(), OK: true}
+
+		// A loop that hasn't started yet is still starting up, not wedged
+		if heartbeat, ok := jobHeartbeat(job); ok {
+			health.Heartbeat = timePtr(heartbeat)
+			if now.Sub(heartbeat) > maxAge {
+				health.OK = false
+			}
+		}
+
+		status, ok := jobStatus(job)
+		if ok {
+			health.LastSync = timePtr(status.finished)
+			health.LastSuccess = timePtr(status.lastSuccess)
+			if status.err != nil {
+				health.LastError = status.err.Error()
+			}
+		}
+		if ready && (status.lastSuccess.IsZero() || now.Sub(status.lastSuccess) > maxAge) {
+			health.OK = false
+		}
+
+		if !health.OK {
+			report.OK = false
+		}
+		report.Jobs = append(report.Jobs, health)
+	}
+
+	return report
+}
+
+var (
+	healthMu sync.Mutex
+	// healthIntervals is --health-intervals, kept apart from the options so
+	// that the HTTP handlers don't race with a reload
+	healthIntervals = 3
+)
+
+// setHealthIntervals sets how many intervals the health checks allow.
+func setHealthIntervals(n int) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	healthIntervals = n
+}
+
+func currentHealthIntervals() int {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return healthIntervals
+}
+
+// healthHandler returns a handler for /healthz, or for /readyz if ready is
+// true.  The status code is 200 if every job is OK and 503 otherwise.
+func healthHandler(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checkHealth(runningJobs(), time.Now(), currentHealthIntervals(), ready)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !report.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Warn("Could not write health report: ", err)
+		}
+	}
+}
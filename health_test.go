@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHealth(t *testing.T) {
+	resetStatus()
+	now := time.Unix(1564500000, 0)
+	job := syncJob{file: "/etc/hosts", domain: "health.test.com", interval: time.Minute}
+
+	// Not started yet, so healthy but not ready
+	report := checkHealth([]syncJob{job}, now, 3, false)
+	assert.True(t, report.OK)
+	report = checkHealth([]syncJob{job}, now, 3, true)
+	assert.False(t, report.OK)
+
+	recordHeartbeat(job, now.Add(-2*time.Minute))
+	recordStatus(job, syncStatus{finished: now.Add(-2 * time.Minute)})
+	recordStatus(job, syncStatus{finished: now.Add(-time.Minute), err: errors.New("rate exceeded")})
+
+	report = checkHealth([]syncJob{job}, now, 3, true)
+	assert.True(t, report.OK)
+	assert.Equal(t, "rate exceeded", report.Jobs[0].LastError)
+	assert.Equal(t, now.Add(-time.Minute), *report.Jobs[0].LastSync)
+	assert.Equal(t, now.Add(-2*time.Minute), *report.Jobs[0].LastSuccess)
+
+	// Past the limit the job is no longer ready, and its loop looks wedged
+	later := now.Add(2 * time.Minute)
+	report = checkHealth([]syncJob{job}, later, 3, true)
+	assert.False(t, report.OK)
+	assert.False(t, report.Jobs[0].OK)
+	report = checkHealth([]syncJob{job}, later, 3, false)
+	assert.False(t, report.OK)
+
+	recordHeartbeat(job, later)
+	report = checkHealth([]syncJob{job}, later, 3, false)
+	assert.True(t, report.OK)
+}
+
+func TestHealthHandler(t *testing.T) {
+	resetStatus()
+	job := syncJob{file: "/etc/hosts", domain: "handler.health.test.com", interval: time.Minute}
+	setDaemonJobs([]syncJob{job}, nil)
+	defer setDaemonJobs(nil, nil)
+
+	w := httptest.NewRecorder()
+	healthHandler(true)(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	recordHeartbeat(job, time.Now())
+	recordStatus(job, syncStatus{finished: time.Now()})
+
+	w = httptest.NewRecorder()
+	healthHandler(true)(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+
+	report := healthReport{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.OK)
+	assert.Equal(t, "handler.health.test.com (/etc/hosts)", report.Jobs[0].Job)
+	assert.Empty(t, report.Jobs[0].LastError)
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/route53"
 )
@@ -14,14 +17,101 @@ import (
 type hostEntry struct {
 	hostname string
 	ip       net.IP
-	// Aliases are read from the /etc/hosts file, but not mapped to Route53
+	// Aliases are read from the /etc/hosts file, and only published (as
+	// CNAME records) when --alias-cnames is given
 	aliases []string
+	// rtype and target are only set for records that don't hold an address,
+	// like CNAMEs.  target is the record value.
+	rtype  string
+	target string
+	// ttl is the record TTL in seconds.  Zero means the --ttl default is
+	// used.
+	ttl int64
+	// skip, zone and cnameAliases are set by a "# r53:" annotation in the
+	// hosts file.  skip leaves the host alone entirely, zone syncs it to a
+	// zone other than --domain and cnameAliases (aliases=cname) publishes its
+	// aliases as CNAME records.
+	skip         bool
+	zone         string
+	cnameAliases bool
 	// rrset only exists for imported Route 53 records
 	rrset *route53.ResourceRecordSet
 }
 
 type hostList []hostEntry
 
+// recordType returns the DNS record type used to publish this host.  IPv4
+// addresses map to A records and everything else to AAAA records.
+func (h hostEntry) recordType() string {
+	if h.rtype != "" {
+		return h.rtype
+	}
+	if h.ip.To4() != nil {
+		return "A"
+	}
+	return "AAAA"
+}
+
+// value returns the record value as it is published in DNS.
+func (h hostEntry) value() string {
+	if h.rtype != "" {
+		return h.target
+	}
+	return h.ip.String()
+}
+
+// key identifies the record set a host maps to.  Hosts with both IPv4 and
+// IPv6 addresses have one key per record type, so each is managed
+// independently.
+func (h hostEntry) key() string {
+	return h.hostname + "/" + h.recordType()
+}
+
+// recordTTL returns the TTL to publish the host with, falling back to def if
+// the host doesn't have one of its own.
+func (h hostEntry) recordTTL(def int64) int64 {
+	if h.ttl != 0 {
+		return h.ttl
+	}
+	return def
+}
+
+// ttl returns the TTL of a record set.  A record set can only have one TTL,
+// so if the hosts disagree the lowest is used.
+func (h hostList) ttl(def int64) int64 {
+	ttl := int64(0)
+	for _, host := range h {
+		if t := host.recordTTL(def); ttl == 0 || t < ttl {
+			ttl = t
+		}
+	}
+	return ttl
+}
+
+// values returns the sorted values of the hosts in the list.
+func (h hostList) values() []string {
+	values := make([]string, 0, len(h))
+	for _, host := range h {
+		values = append(values, host.value())
+	}
+	sort.Strings(values)
+	return values
+}
+
+// groupByKey groups hosts into record sets by name and type.  The keys are
+// returned in the order they first appear.
+func groupByKey(hosts hostList) (map[string]hostList, []string) {
+	groups := map[string]hostList{}
+	keys := []string{}
+	for _, h := range hosts {
+		if _, ok := groups[h.key()]; !ok {
+			keys = append(keys, h.key())
+		}
+		groups[h.key()] = append(groups[h.key()], h)
+	}
+	return groups, keys
+}
+
 func (h hostList) Len() int {
 	return len(h)
 }
@@ -30,7 +120,13 @@ func (h hostList) Less(i, j int) bool {
 	if h[i].hostname != h[j].hostname {
 		return h[i].hostname < h[j].hostname
 	}
-	return bytes.Compare(h[i].ip, h[j].ip) < 0
+	if h[i].recordType() != h[j].recordType() {
+		return h[i].recordType() < h[j].recordType()
+	}
+	if c := bytes.Compare(h[i].ip, h[j].ip); c != 0 {
+		return c < 0
+	}
+	return h[i].target < h[j].target
 }
 
 func (h hostList) Swap(i, j int) {
@@ -38,7 +134,9 @@ func (h hostList) Swap(i, j int) {
 }
 
 func parseLine(line string) (*hostEntry, error) {
+	comment := ""
 	if i := strings.Index(line, "#"); i >= 0 {
+		comment = line[i+1:]
 		line = line[0:i]
 	}
 
@@ -51,18 +149,99 @@ func parseLine(line string) (*hostEntry, error) {
 		return nil, fmt.Errorf("should contain at least two fields")
 	}
 
-	if ip := net.ParseIP(parts[0]); ip != nil {
-		return &hostEntry{
-			hostname: parts[1],
-			ip:       ip,
-			aliases:  parts[2:],
-		}, nil
+	ip := net.ParseIP(parts[0])
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not a valid IP", parts[0])
+	}
+
+	host := &hostEntry{
+		hostname: parts[1],
+		ip:       ip,
+		aliases:  parts[2:],
+	}
+	parseAnnotations(comment, host)
+
+	return host, nil
+}
+
+// parseAnnotations applies the settings in the comment at the end of a hosts
+// file line to h.  Settings follow an "r53:" prefix, like
+// "# r53: ttl=300 zone=dmz.example.com".  A plain "# ttl=60" comment is also
+// accepted, and any other comment is ignored.  Invalid settings are ignored
+// with a warning, rather than dropping the host and deleting its records
+// over a typo in a comment.
+func parseAnnotations(comment string, h *hostEntry) {
+	comment = strings.TrimSpace(comment)
+	if !strings.HasPrefix(comment, "r53:") {
+		// Only a comment that is nothing but the TTL counts, so that an
+		// ordinary comment mentioning one doesn't change anything.  Since
+		// it's only a comment, a bad TTL leaves the line as it is.
+		if strings.HasPrefix(comment, "ttl=") && len(strings.Fields(comment)) == 1 {
+			if err := parseAnnotation(comment, h); err != nil {
+				log.Warnf("%v for %v, ignoring", err, h.hostname)
+			}
+		}
+		return
+	}
+
+	for _, field := range strings.Fields(strings.TrimPrefix(comment, "r53:")) {
+		if err := parseAnnotation(field, h); err != nil {
+			log.Warnf("%v for %v, ignoring", err, h.hostname)
+		}
+	}
+}
+
+// parseAnnotation applies a single annotation setting to h.
+func parseAnnotation(field string, h *hostEntry) error {
+	if field == "skip" {
+		h.skip = true
+		return nil
+	}
+
+	kv := strings.SplitN(field, "=", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return fmt.Errorf("%s is not a valid annotation", field)
 	}
 
-	return nil, fmt.Errorf("%s is not a valid IP", parts[0])
+	switch kv[0] {
+	case "ttl":
+		ttl, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("%s is not a valid TTL", field)
+		}
+		h.ttl = ttl
+	case "zone":
+		h.zone = canonifyHostname(kv[1])
+	case "aliases":
+		if strings.ToLower(kv[1]) != "cname" {
+			return fmt.Errorf("%s is not a valid annotation", field)
+		}
+		h.cnameAliases = true
+	default:
+		return fmt.Errorf("%s is not a valid annotation", field)
+	}
+
+	return nil
 }
 
-func readHosts(filename string) (hosts hostList) {
+// setDefaultTTL returns a copy of hosts with any host that doesn't have a TTL
+// of its own given ttl.
+func setDefaultTTL(hosts hostList, ttl int64) hostList {
+	result := make(hostList, 0, len(hosts))
+	for _, h := range hosts {
+		h.ttl = h.recordTTL(ttl)
+		result = append(result, h)
+	}
+	return result
+}
+
+// lineParser parses a single line of an input file.  It returns nil without
+// an error for lines that should be silently skipped.
+type lineParser func(line string) (*hostEntry, error)
+
+// readLines reads an input file line by line, converting each line into a
+// host with the given parser.
+func readLines(filename string, parse lineParser) (hosts hostList) {
 	file, err := os.Open(filename)
 
 	if err != nil {
@@ -73,11 +252,13 @@ func readHosts(filename string) (hosts hostList) {
 
 	scanner := bufio.NewScanner(file)
 	i := 0
+	skipped := 0
 	for scanner.Scan() {
 		i++
-		host, err := parseLine(scanner.Text())
+		host, err := parse(scanner.Text())
 		if err != nil {
 			log.Warnf("%v on line %v, skipping\n", err, i)
+			skipped++
 			continue
 		}
 		if host != nil {
@@ -90,9 +271,31 @@ func readHosts(filename string) (hosts hostList) {
 		log.Fatal(err)
 	}
 
+	hostsParsed.set(float64(len(hosts)), filename)
+	hostsSkipped.set(float64(skipped), filename)
+
 	return
 }
 
+func readHosts(filename string) hostList {
+	return readLines(filename, parseLine)
+}
+
+// readInput reads hosts from filename in the format selected with
+// --input-format.
+func readInput(filename string) hostList {
+	switch opts.InputFormat {
+	case "dnsmasq-leases":
+		return readDnsmasqLeases(filename, opts.SkipExpiredLeases, time.Now())
+	case "isc-dhcpd-leases":
+		return readDhcpdLeases(filename, time.Now())
+	case "kea-leases":
+		return readKeaLeases(filename, time.Now())
+	default:
+		return readHosts(filename)
+	}
+}
+
 func filterHostsByNetwork(hosts hostList, networks []CIDRNet) hostList {
 	output := hostList{}
 	for _, host := range hosts {
@@ -106,6 +309,130 @@ func filterHostsByNetwork(hosts hostList, networks []CIDRNet) hostList {
 	return output
 }
 
+// removeSkippedHosts removes the hosts annotated with skip, returning the
+// remaining hosts and the names of the hosts that were removed.
+func removeSkippedHosts(hosts hostList) (hostList, []string) {
+	result := make(hostList, 0, len(hosts))
+	skipped := []string{}
+	for _, h := range hosts {
+		if h.skip {
+			skipped = append(skipped, h.hostname)
+			continue
+		}
+		result = append(result, h)
+	}
+
+	return result, skipped
+}
+
+// hostsByZone groups hosts by the zone they are synced to, which is domain
+// unless the host has a zone annotation naming one of the other zones.  Every
+// zone is returned, even if no host is synced to it, so that records left
+// from annotations that have since been removed are cleaned up.  The zones
+// are returned in the order given, starting with domain.
+func hostsByZone(hosts hostList, domain string, others []string) (map[string]hostList, []string) {
+	zones := map[string]hostList{domain: {}}
+	domains := []string{domain}
+	for _, zone := range others {
+		if _, ok := zones[zone]; !ok {
+			zones[zone] = hostList{}
+			domains = append(domains, zone)
+		}
+	}
+
+	for _, h := range hosts {
+		zone := domain
+		if h.zone != "" {
+			if _, ok := zones[h.zone]; ok {
+				zone = h.zone
+			} else {
+				log.Warnf("%v is annotated with zone %v, which isn't given with --zone, ignoring",
+					h.hostname, h.zone)
+			}
+		}
+		zones[zone] = append(zones[zone], h)
+	}
+
+	return zones, domains
+}
+
+// aliasCNAMEs returns a CNAME record for each alias of the given hosts,
+// pointing at the canonical hostname.
+func aliasCNAMEs(hosts hostList) hostList {
+	cnames := hostList{}
+	for _, h := range hosts {
+		for _, alias := range h.aliases {
+			cnames = append(cnames, hostEntry{
+				hostname: canonifyHostname(alias),
+				rtype:    "CNAME",
+				target:   h.hostname,
+				ttl:      h.ttl,
+			})
+		}
+	}
+
+	return cnames
+}
+
+// removeConflictingCNAMEs drops CNAMEs that point at themselves or that have
+// the same name as one of the address records in hosts, since a CNAME can't
+// coexist with other records.
+func removeConflictingCNAMEs(cnames hostList, hosts hostList) hostList {
+	names := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		names[h.hostname] = true
+	}
+
+	result := make(hostList, 0, len(cnames))
+	for _, c := range cnames {
+		if c.hostname == c.target {
+			continue
+		}
+		if names[c.hostname] {
+			log.Warnf("Alias %v conflicts with an existing host, ignoring", c.hostname)
+			continue
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
+
+// filterListedCNAMEs returns the CNAMEs in hosts that have the same name and
+// target as one of the aliases in listed.
+func filterListedCNAMEs(hosts hostList, listed hostList) hostList {
+	aliases := make(map[string]bool, len(listed))
+	for _, c := range listed {
+		aliases[c.key()+"/"+c.value()] = true
+	}
+
+	output := hostList{}
+	for _, h := range hosts {
+		if h.recordType() == "CNAME" && aliases[h.key()+"/"+h.value()] {
+			output = append(output, h)
+		}
+	}
+	return output
+}
+
+// filterCNAMEsByTarget returns the CNAMEs in hosts that point at one of the
+// hosts in managed.  This is used to find which CNAMEs in a zone belong to
+// the networks being synced, since they have no address of their own.
+func filterCNAMEsByTarget(hosts hostList, managed hostList) hostList {
+	targets := make(map[string]bool, len(managed))
+	for _, h := range managed {
+		targets[h.hostname] = true
+	}
+
+	output := hostList{}
+	for _, h := range hosts {
+		if h.recordType() == "CNAME" && targets[h.target] {
+			output = append(output, h)
+		}
+	}
+	return output
+}
+
 func qualifyHosts(hosts hostList, domain string) hostList {
 	result := make(hostList, len(hosts))
 	for i, h := range hosts {
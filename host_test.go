@@ -47,3 +47,170 @@ func TestSortHostList(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		name   string
+		line   string
+		host   *hostEntry
+		hasErr bool
+	}{
+		{"blank", "   ", nil, false},
+		{"comment", "# 1.2.3.4 test1", nil, false},
+		{"host",
+			"1.2.3.4 test1 www # a comment",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{"www"}},
+			false},
+		{"ttl",
+			"1.2.3.4 test1 # ttl=60",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}, ttl: 60},
+			false},
+		{"bad-ttl",
+			"1.2.3.4 test1 # ttl=soon",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}},
+			false},
+		{"zero-ttl",
+			"1.2.3.4 test1 # ttl=0",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}},
+			false},
+		{"ttl-in-comment",
+			"1.2.3.4 test1 # see ttl=notes, or ttl=60",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}},
+			false},
+		{"annotations",
+			"1.2.3.4 test1 www # r53: ttl=300 zone=DMZ.test.com. aliases=CNAME",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{"www"}, ttl: 300,
+				zone: "dmz.test.com", cnameAliases: true},
+			false},
+		{"skip",
+			"1.2.3.4 test1 #r53:skip",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}, skip: true},
+			false},
+		// Bad annotations are ignored, leaving the rest of the line
+		{"bad-aliases",
+			"1.2.3.4 test1 www # r53: aliases=a ttl=60",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{"www"}, ttl: 60},
+			false},
+		{"type",
+			"1.2.3.4 test1 # r53: type=cname ttl=60",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}, ttl: 60},
+			false},
+		{"unknown-annotation",
+			"1.2.3.4 test1 # r53: tll=60 color=blue",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}},
+			false},
+		{"empty-zone",
+			"1.2.3.4 test1 # r53: zone=",
+			&hostEntry{hostname: "test1", ip: net.ParseIP("1.2.3.4"), aliases: []string{}},
+			false},
+		{"bad-ip", "1.2.3 test1", nil, true},
+		{"missing-name", "1.2.3.4", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host, err := parseLine(c.line)
+			assert.Equal(t, c.hasErr, err != nil)
+			assert.Equal(t, c.host, host)
+		})
+	}
+}
+
+func TestHostsByZone(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1", ip: net.ParseIP("1.2.3.4"), zone: "dmz.test.com"},
+		{hostname: "test2", ip: net.ParseIP("1.2.3.5")},
+		{hostname: "test3", ip: net.ParseIP("1.2.3.6"), zone: "dmz.test.com"},
+	}
+
+	zones, domains := hostsByZone(hosts, "test.com", []string{"dmz.test.com"})
+	assert.Equal(t, []string{"test.com", "dmz.test.com"}, domains)
+	assert.Equal(t, hostList{hosts[1]}, zones["test.com"])
+	assert.Equal(t, hostList{hosts[0], hosts[2]}, zones["dmz.test.com"])
+
+	// Zones are synced even if no host is annotated with them
+	zones, domains = hostsByZone(hostList{}, "test.com", []string{"dmz.test.com"})
+	assert.Equal(t, []string{"test.com", "dmz.test.com"}, domains)
+	assert.Equal(t, hostList{}, zones["test.com"])
+	assert.Equal(t, hostList{}, zones["dmz.test.com"])
+
+	// Annotations naming any other zone are ignored
+	zones, domains = hostsByZone(hosts, "test.com", nil)
+	assert.Equal(t, []string{"test.com"}, domains)
+	assert.Equal(t, hosts, zones["test.com"])
+}
+
+func TestRemoveSkippedHosts(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), skip: true},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+
+	result, skipped := removeSkippedHosts(hosts)
+	assert.Equal(t, hostList{hosts[1]}, result)
+	assert.Equal(t, []string{"test1.test.com"}, skipped)
+}
+
+func TestSetDefaultTTL(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5"), ttl: 60},
+	}
+
+	assert.Equal(t, hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 3600},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5"), ttl: 60},
+	}, setDefaultTTL(hosts, 3600))
+}
+
+func TestAliasCNAMEs(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), aliases: []string{"www", "Mail."}},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+
+	cnames := qualifyHosts(aliasCNAMEs(hosts), "test.com")
+	assert.Equal(t, hostList{
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "mail.test.com", rtype: "CNAME", target: "test1.test.com"},
+	}, cnames)
+}
+
+func TestRemoveConflictingCNAMEs(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+	cnames := hostList{
+		{hostname: "test1.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "test2.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+	}
+
+	assert.Equal(t, cnames[2:], removeConflictingCNAMEs(cnames, hosts))
+}
+
+func TestFilterListedCNAMEs(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "ftp.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "mail.test.com", rtype: "CNAME", target: "test2.test.com"},
+	}
+	listed := hostList{
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com", ttl: 60},
+		{hostname: "mail.test.com", rtype: "CNAME", target: "test1.test.com"},
+	}
+
+	assert.Equal(t, hosts[1:2], filterListedCNAMEs(hosts, listed))
+}
+
+func TestFilterCNAMEsByTarget(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+		{hostname: "other.test.com", rtype: "CNAME", target: "elsewhere.example.com"},
+	}
+
+	assert.Equal(t, hosts[1:2], filterCNAMEsByTarget(hosts, hosts[:1]))
+}
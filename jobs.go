@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncJob is a single input file kept in sync with a domain.  Without any
+// --job options there is one job built from the top level options.
+type syncJob struct {
+	file   string
+	domain string
+	// zones are the other zones that hosts can be moved to with a zone=
+	// annotation.  They are synced along with domain.
+	zones    []string
+	networks []CIDRNet
+	ttl      int64
+	excludes []string
+	interval time.Duration
+}
+
+// syncJobs holds the jobs to run, set up by parseOpts and replaced when the
+// daemon reloads its configuration.
+var syncJobs []syncJob
+
+func (j syncJob) String() string {
+	return fmt.Sprintf("%v (%v)", j.domain, j.file)
+}
+
+// defaultJob returns the job described by the top level options, which also
+// supplies the defaults for anything not given in a --job option.
+func defaultJob(o options) syncJob {
+	return syncJob{
+		file:     o.File,
+		domain:   o.Domain,
+		zones:    o.Zones,
+		networks: o.Networks,
+		ttl:      o.TTL,
+		excludes: o.ExcludeHosts,
+		interval: o.Interval,
+	}
+}
+
+// parseJob parses a --job option, a comma separated list of key=value
+// settings like "file=/etc/hosts.lan,domain=lan.example.com,network=10.0.0.0/8".
+// zone, network and exclude can be given more than once.  Settings that
+// aren't given are taken from defaults.
+func parseJob(spec string, defaults syncJob) (syncJob, error) {
+	job := defaults
+	zones := []string{}
+	networks := []CIDRNet{}
+	excludes := []string{}
+
+	for _, setting := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return syncJob{}, fmt.Errorf("invalid job setting '%v'", setting)
+		}
+
+		switch kv[0] {
+		case "file":
+			job.file = kv[1]
+		case "domain":
+			job.domain = kv[1]
+		case "zone":
+			zones = append(zones, kv[1])
+		case "network":
+			var n CIDRNet
+			if err := n.UnmarshalFlag(kv[1]); err != nil {
+				return syncJob{}, fmt.Errorf("invalid network '%v' in job: %v", kv[1], err)
+			}
+			networks = append(networks, n)
+		case "ttl":
+			ttl, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || ttl <= 0 {
+				return syncJob{}, fmt.Errorf("invalid TTL '%v' in job", kv[1])
+			}
+			job.ttl = ttl
+		case "exclude":
+			excludes = append(excludes, kv[1])
+		case "interval":
+			interval, err := time.ParseDuration(kv[1])
+			if err != nil || interval <= 0 {
+				return syncJob{}, fmt.Errorf("invalid interval '%v' in job", kv[1])
+			}
+			job.interval = interval
+		default:
+			return syncJob{}, fmt.Errorf("unknown job setting '%v'", kv[0])
+		}
+	}
+
+	if len(zones) > 0 {
+		job.zones = zones
+	}
+	if len(networks) > 0 {
+		job.networks = networks
+	}
+	if len(excludes) > 0 {
+		job.excludes = excludes
+	}
+
+	return job, nil
+}
+
+// validate checks that a job has everything it needs, and cleans up the
+// domain and zone names.
+func (j *syncJob) validate() error {
+	if j.domain == "" {
+		return fmt.Errorf("domain name must be specified (-d or --domain)")
+	}
+
+	if len(j.networks) == 0 {
+		return fmt.Errorf("one or more networks must be provided (--network)")
+	}
+
+	// The file is made absolute so that a job always has the same name, no
+	// matter where it was started from
+	file, err := filepath.Abs(j.file)
+	if err != nil {
+		return fmt.Errorf("cannot convert %v to absolute path: %v", j.file, err)
+	}
+	j.file = file
+
+	// Accept trailing dot, but ignore it for consistency sake
+	j.domain = strings.TrimSuffix(j.domain, ".")
+	if err := validateDomain(j.domain); err != nil {
+		return err
+	}
+
+	// Zones are compared with zone= annotations, which are canonified the
+	// same way
+	zones := make([]string, 0, len(j.zones))
+	for _, zone := range j.zones {
+		zone = canonifyHostname(zone)
+		if err := validateDomain(zone); err != nil {
+			return err
+		}
+		if strings.EqualFold(zone, j.domain) {
+			continue
+		}
+		zones = append(zones, zone)
+	}
+	j.zones = zones
+
+	return nil
+}
+
+// allZones returns every zone the job syncs, starting with its domain.
+func (j syncJob) allZones() []string {
+	return append([]string{j.domain}, j.zones...)
+}
+
+// validateDomain checks that name is a syntactically valid domain name.
+// Underscores are allowed, since they are common in zones that hold service
+// records.
+func validateDomain(name string) error {
+	if len(name) > 253 {
+		return fmt.Errorf("domain '%v' is too long", name)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("domain '%v' has an empty or too long label", name)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("domain '%v' has a label starting or ending with a hyphen", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+				c == '-' || c == '_') {
+				return fmt.Errorf("domain '%v' contains invalid character '%c'", name, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseJobs returns the jobs described by the options, either one for each
+// --job option or a single job from the top level options.
+func parseJobs(o options) ([]syncJob, error) {
+	if len(o.Jobs) == 0 {
+		job := defaultJob(o)
+		if err := job.validate(); err != nil {
+			return nil, err
+		}
+		return []syncJob{job}, nil
+	}
+
+	jobs := make([]syncJob, 0, len(o.Jobs))
+	for _, spec := range o.Jobs {
+		job, err := parseJob(spec, defaultJob(o))
+		if err != nil {
+			return nil, err
+		}
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("job '%v': %v", spec, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := checkJobConflicts(jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// checkJobConflicts makes sure no two jobs manage the same records.  Jobs
+// syncing the same zone with overlapping networks would delete each other's
+// records on every run, and jobs are told apart by their domain and file, so
+// those have to be unique too.
+func checkJobConflicts(jobs []syncJob) error {
+	for i, a := range jobs {
+		for _, b := range jobs[i+1:] {
+			if strings.EqualFold(a.domain, b.domain) && a.file == b.file {
+				return fmt.Errorf("jobs for %v use the same file and domain", a)
+			}
+			zone, ok := sharedZone(a, b)
+			if !ok {
+				continue
+			}
+			for _, an := range a.networks {
+				for _, bn := range b.networks {
+					if an.overlaps(bn) {
+						return fmt.Errorf("jobs %v and %v both sync %v with overlapping networks %v and %v",
+							a, b, zone, an.IPNet.String(), bn.IPNet.String())
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// sharedZone returns a zone that both a and b sync, if there is one.
+func sharedZone(a syncJob, b syncJob) (string, bool) {
+	for _, az := range a.allZones() {
+		for _, bz := range b.allZones() {
+			if strings.EqualFold(az, bz) {
+				return az, true
+			}
+		}
+	}
+	return "", false
+}
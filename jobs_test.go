@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJob(t *testing.T) {
+	var lan, dmz CIDRNet
+	lan.UnmarshalFlag("10.0.0.0/8")
+	dmz.UnmarshalFlag("192.168.10.0/24")
+	defaults := syncJob{
+		file:     "/etc/hosts",
+		networks: []CIDRNet{lan},
+		ttl:      3600,
+		excludes: []string{"gw.test.com"},
+		interval: 15 * time.Minute,
+	}
+
+	job, err := parseJob("file=/etc/hosts.dmz, domain=dmz.test.com,zone=a.test.com,zone=b.test.com,"+
+		"network=192.168.10.0/24,ttl=300,interval=5m", defaults)
+	assert.Nil(t, err)
+	assert.Equal(t, syncJob{
+		file:     "/etc/hosts.dmz",
+		domain:   "dmz.test.com",
+		zones:    []string{"a.test.com", "b.test.com"},
+		networks: []CIDRNet{dmz},
+		ttl:      300,
+		excludes: []string{"gw.test.com"},
+		interval: 5 * time.Minute,
+	}, job)
+
+	job, err = parseJob("domain=test.com,exclude=a.test.com,exclude=b.test.com", defaults)
+	assert.Nil(t, err)
+	assert.Equal(t, []CIDRNet{lan}, job.networks)
+	assert.Equal(t, []string{"a.test.com", "b.test.com"}, job.excludes)
+
+	for _, spec := range []string{
+		"domain",
+		"domain=",
+		"color=blue",
+		"network=10.0.0.0",
+		"ttl=-1",
+		"interval=soon",
+	} {
+		_, err := parseJob(spec, defaults)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestSyncJobValidate(t *testing.T) {
+	var n CIDRNet
+	n.UnmarshalFlag("10.0.0.0/8")
+
+	job := syncJob{domain: "test.com.", zones: []string{"DMZ.test.com.", "test.com"}, networks: []CIDRNet{n}}
+	assert.Nil(t, job.validate())
+	assert.Equal(t, "test.com", job.domain)
+	assert.Equal(t, []string{"dmz.test.com"}, job.zones)
+	assert.Equal(t, []string{"test.com", "dmz.test.com"}, job.allZones())
+
+	job = syncJob{domain: "test.com", zones: []string{"dmz..test.com"}, networks: []CIDRNet{n}}
+	assert.NotNil(t, job.validate())
+
+	job = syncJob{networks: []CIDRNet{n}}
+	assert.NotNil(t, job.validate())
+
+	job = syncJob{domain: "test.com"}
+	assert.NotNil(t, job.validate())
+}
+
+func TestParseJobs(t *testing.T) {
+	var lan CIDRNet
+	lan.UnmarshalFlag("10.0.0.0/8")
+	o := options{File: "/etc/hosts", Networks: []CIDRNet{lan}, TTL: 3600, Interval: 15 * time.Minute}
+
+	o.Jobs = []string{
+		"file=/etc/hosts.lan,domain=lan.test.com",
+		"file=/etc/hosts.dmz,domain=dmz.test.com",
+		// Same domain, but the networks don't overlap
+		"file=/etc/hosts.wifi,domain=lan.test.com,network=192.168.0.0/16",
+		"file=/etc/hosts.srv,domain=srv.test.com,zone=wifi.test.com,network=172.16.0.0/12",
+	}
+	jobs, err := parseJobs(o)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(jobs))
+
+	for _, specs := range [][]string{
+		{"file=/etc/hosts.lan,domain=lan.test.com", "file=/etc/hosts.wifi,domain=lan.test.com"},
+		{"file=/etc/hosts.lan,domain=lan.test.com", "file=/etc/hosts.wifi,domain=LAN.test.com.,network=10.1.0.0/16"},
+		{"file=/etc/hosts.lan,domain=lan.test.com,network=10.0.0.0/16", "file=/etc/hosts.lan,domain=lan.test.com,network=10.1.0.0/16"},
+		// A zone for annotations is synced like the domain
+		{"file=/etc/hosts.lan,domain=lan.test.com", "file=/etc/hosts.dmz,domain=dmz.test.com,zone=lan.test.com"},
+		{"file=/etc/hosts.lan,domain=lan.test.com,zone=srv.test.com", "file=/etc/hosts.dmz,domain=dmz.test.com,zone=SRV.test.com"},
+	} {
+		o.Jobs = specs
+		_, err := parseJobs(o)
+		assert.NotNil(t, err, specs)
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	for _, name := range []string{"test.com", "_srv.test.com", "a-b.test.com", "10.in-addr.arpa"} {
+		assert.Nil(t, validateDomain(name), name)
+	}
+
+	for _, name := range []string{"", "test..com", "-a.test.com", "a-.test.com", "test com", "tést.com"} {
+		assert.NotNil(t, validateDomain(name), name)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// parseDnsmasqLease parses a line from a dnsmasq lease file.  Each line has
+// the lease expiry time, MAC address (or IAID for DHCPv6), IP address,
+// hostname and client ID.  Leases without a hostname are skipped, as are
+// expired leases if skipExpired is set.
+func parseDnsmasqLease(line string, skipExpired bool, now time.Time) (*hostEntry, error) {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	// DHCPv6 lease files include the server DUID on a line of its own
+	if parts[0] == "duid" {
+		return nil, nil
+	}
+
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("should contain at least four fields")
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid expiry time", parts[0])
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not a valid IP", parts[2])
+	}
+
+	// dnsmasq uses * when the client didn't supply a hostname
+	if parts[3] == "*" {
+		return nil, nil
+	}
+
+	// An expiry time of zero means the lease is infinite
+	if skipExpired && expiry != 0 && time.Unix(expiry, 0).Before(now) {
+		log.Debugf("Lease for %v (%v) expired at %v, skipping",
+			parts[3], parts[2], time.Unix(expiry, 0))
+		return nil, nil
+	}
+
+	return &hostEntry{
+		hostname: parts[3],
+		ip:       ip,
+	}, nil
+}
+
+func readDnsmasqLeases(filename string, skipExpired bool, now time.Time) hostList {
+	return readLines(filename, func(line string) (*hostEntry, error) {
+		return parseDnsmasqLease(line, skipExpired, now)
+	})
+}
+
+// dhcpdLease holds the parts of an ISC dhcpd lease block that we care about.
+type dhcpdLease struct {
+	ip       net.IP
+	hostname string
+	active   bool
+	ends     time.Time
+}
+
+// tokenizeDhcpdLeases splits the contents of a dhcpd.leases file into
+// tokens.  Braces and semicolons are returned as tokens of their own, quotes
+// are removed from strings and comments are dropped.
+func tokenizeDhcpdLeases(data string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(data) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, data[i+1:end])
+			i = end + 1
+		default:
+			end := i
+			for end < len(data) && !strings.ContainsRune(" \t\r\n{};\"#", rune(data[end])) {
+				end++
+			}
+			tokens = append(tokens, data[i:end])
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseDhcpdEnds parses the value of an "ends" statement, which is either
+// "never", "epoch <seconds>" or "<weekday> <yyyy/mm/dd> <hh:mm:ss>" in UTC.
+func parseDhcpdEnds(args []string) (time.Time, error) {
+	switch {
+	case len(args) == 1 && args[0] == "never":
+		return time.Time{}, nil
+	case len(args) == 2 && args[0] == "epoch":
+		secs, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s is not a valid epoch time", args[1])
+		}
+		return time.Unix(secs, 0), nil
+	case len(args) == 3:
+		return time.Parse("2006/01/02 15:04:05", args[1]+" "+args[2])
+	}
+
+	return time.Time{}, fmt.Errorf("cannot parse end time '%v'", strings.Join(args, " "))
+}
+
+// parseDhcpdLeases parses the contents of an ISC dhcpd lease file.  The file
+// is a journal, so a later lease block for an address replaces any earlier
+// one.  Only leases with an active binding that haven't ended are returned,
+// along with the number of lease blocks skipped because they were invalid.
+func parseDhcpdLeases(data string, now time.Time) (hostList, int, error) {
+	tokens, err := tokenizeDhcpdLeases(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	leases := map[string]*dhcpdLease{}
+	order := []string{}
+	skipped := 0
+	for i := 0; i < len(tokens); {
+		// Collect a single statement, which ends with either a semicolon
+		// or a block.
+		start := i
+		for i < len(tokens) && tokens[i] != ";" && tokens[i] != "{" {
+			i++
+		}
+		if i >= len(tokens) {
+			break
+		}
+		stmt := tokens[start:i]
+		if tokens[i] == ";" {
+			i++
+			continue
+		}
+
+		// Find the matching close brace for this block
+		i++
+		blockStart := i
+		depth := 1
+		for i < len(tokens) && depth > 0 {
+			if tokens[i] == "{" {
+				depth++
+			} else if tokens[i] == "}" {
+				depth--
+			}
+			i++
+		}
+		if depth > 0 {
+			return nil, 0, fmt.Errorf("unterminated block")
+		}
+		block := tokens[blockStart : i-1]
+
+		if len(stmt) != 2 || stmt[0] != "lease" {
+			continue
+		}
+
+		lease, err := parseDhcpdLease(stmt[1], block)
+		if err != nil {
+			log.Warnf("%v in lease for %v, skipping", err, stmt[1])
+			skipped++
+			continue
+		}
+		if _, ok := leases[stmt[1]]; !ok {
+			order = append(order, stmt[1])
+		}
+		leases[stmt[1]] = lease
+	}
+
+	hosts := hostList{}
+	for _, addr := range order {
+		lease := leases[addr]
+		if !lease.active || lease.hostname == "" {
+			continue
+		}
+		if !lease.ends.IsZero() && lease.ends.Before(now) {
+			log.Debugf("Lease for %v (%v) ended at %v, skipping",
+				lease.hostname, addr, lease.ends)
+			continue
+		}
+		hosts = append(hosts, hostEntry{hostname: lease.hostname, ip: lease.ip})
+	}
+
+	return hosts, skipped, nil
+}
+
+func parseDhcpdLease(addr string, block []string) (*dhcpdLease, error) {
+	lease := &dhcpdLease{ip: net.ParseIP(addr)}
+	if lease.ip == nil {
+		return nil, fmt.Errorf("%s is not a valid IP", addr)
+	}
+
+	for i := 0; i < len(block); i++ {
+		start := i
+		for i < len(block) && block[i] != ";" {
+			i++
+		}
+		stmt := block[start:i]
+		if len(stmt) == 0 {
+			continue
+		}
+
+		switch stmt[0] {
+		case "binding":
+			lease.active = len(stmt) == 3 && stmt[1] == "state" && stmt[2] == "active"
+		case "ends":
+			ends, err := parseDhcpdEnds(stmt[1:])
+			if err != nil {
+				return nil, err
+			}
+			lease.ends = ends
+		case "client-hostname":
+			if len(stmt) == 2 {
+				lease.hostname = stmt[1]
+			}
+		}
+	}
+
+	return lease, nil
+}
+
+func readDhcpdLeases(filename string, now time.Time) hostList {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hosts, skipped, err := parseDhcpdLeases(string(data), now)
+	if err != nil {
+		log.Fatalf("Cannot parse %v: %v", filename, err)
+	}
+
+	hostsParsed.set(float64(len(hosts)), filename)
+	hostsSkipped.set(float64(skipped), filename)
+
+	return canonifyHosts(hosts)
+}
+
+// parseKeaLeases parses a Kea memfile lease database in CSV format.  Both the
+// DHCPv4 and DHCPv6 formats are supported, since the columns we need have
+// the same names in each.  Later rows for an address replace earlier ones,
+// and only leases in the default (active) state that haven't expired are
+// returned, along with the number of rows skipped because they were invalid.
+func parseKeaLeases(r io.Reader, now time.Time) (hostList, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Cannot read header")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "expire", "hostname", "state"} {
+		if _, ok := columns[name]; !ok {
+			return nil, 0, fmt.Errorf("missing %v column", name)
+		}
+	}
+
+	leases := map[string][]string{}
+	order := []string{}
+	skipped := 0
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if len(row) != len(header) {
+			log.Warnf("Wrong number of fields on line %v, skipping", line)
+			skipped++
+			continue
+		}
+
+		addr := row[columns["address"]]
+		if _, ok := leases[addr]; !ok {
+			order = append(order, addr)
+		}
+		leases[addr] = row
+	}
+
+	hosts := hostList{}
+	for _, addr := range order {
+		row := leases[addr]
+		// Kea escapes commas in hostnames
+		hostname := strings.Replace(row[columns["hostname"]], "&#x2c", ",", -1)
+		if hostname == "" || row[columns["state"]] != "0" {
+			continue
+		}
+
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			log.Warnf("%s is not a valid IP, skipping", addr)
+			skipped++
+			continue
+		}
+
+		expire, err := strconv.ParseInt(row[columns["expire"]], 10, 64)
+		if err != nil {
+			log.Warnf("%s is not a valid expiry time for %v, skipping",
+				row[columns["expire"]], addr)
+			skipped++
+			continue
+		}
+		if time.Unix(expire, 0).Before(now) {
+			log.Debugf("Lease for %v (%v) expired at %v, skipping",
+				hostname, addr, time.Unix(expire, 0))
+			continue
+		}
+
+		hosts = append(hosts, hostEntry{hostname: hostname, ip: ip})
+	}
+
+	return hosts, skipped, nil
+}
+
+func readKeaLeases(filename string, now time.Time) hostList {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	hosts, skipped, err := parseKeaLeases(file, now)
+	if err != nil {
+		log.Fatalf("Cannot parse %v: %v", filename, err)
+	}
+
+	hostsParsed.set(float64(len(hosts)), filename)
+	hostsSkipped.set(float64(skipped), filename)
+
+	return canonifyHosts(hosts)
+}
+
+// canonifyHosts canonicalizes the hostname of every host in the list.
+func canonifyHosts(hosts hostList) hostList {
+	for i := range hosts {
+		hosts[i].hostname = canonifyHostname(hosts[i].hostname)
+	}
+	return hosts
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDnsmasqLease(t *testing.T) {
+	now := time.Unix(1564500000, 0)
+	cases := []struct {
+		name        string
+		line        string
+		skipExpired bool
+		host        *hostEntry
+		err         bool
+	}{
+		{"valid", "1564500100 aa:bb:cc:dd:ee:ff 192.168.1.10 myhost 01:aa:bb:cc:dd:ee:ff", false,
+			&hostEntry{hostname: "myhost", ip: net.ParseIP("192.168.1.10")}, false},
+		{"no client id", "1564500100 aa:bb:cc:dd:ee:ff 192.168.1.10 myhost", false,
+			&hostEntry{hostname: "myhost", ip: net.ParseIP("192.168.1.10")}, false},
+		{"ipv6", "1564500100 1234 2001:db8::10 myhost 00:01:00:01", false,
+			&hostEntry{hostname: "myhost", ip: net.ParseIP("2001:db8::10")}, false},
+		{"no hostname", "1564500100 aa:bb:cc:dd:ee:ff 192.168.1.10 * *", false, nil, false},
+		{"duid", "duid 00:01:00:01:24:aa:bb:cc", false, nil, false},
+		{"blank", "", false, nil, false},
+		{"expired kept", "1564400000 aa:bb:cc:dd:ee:ff 192.168.1.10 myhost *", false,
+			&hostEntry{hostname: "myhost", ip: net.ParseIP("192.168.1.10")}, false},
+		{"expired skipped", "1564400000 aa:bb:cc:dd:ee:ff 192.168.1.10 myhost *", true, nil, false},
+		{"infinite", "0 aa:bb:cc:dd:ee:ff 192.168.1.10 myhost *", true,
+			&hostEntry{hostname: "myhost", ip: net.ParseIP("192.168.1.10")}, false},
+		{"bad expiry", "soon aa:bb:cc:dd:ee:ff 192.168.1.10 myhost *", false, nil, true},
+		{"bad ip", "1564500100 aa:bb:cc:dd:ee:ff 192.168.1 myhost *", false, nil, true},
+		{"short", "1564500100 aa:bb:cc:dd:ee:ff 192.168.1.10", false, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host, err := parseDnsmasqLease(c.line, c.skipExpired, now)
+			assert.Equal(t, c.host, host)
+			assert.Equal(t, c.err, err != nil)
+		})
+	}
+}
+
+func TestReadDnsmasqLeases(t *testing.T) {
+	f, err := ioutil.TempFile("", "leases")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("1564500100 aa:bb:cc:dd:ee:ff 192.168.1.10 MyHost 01:aa:bb:cc:dd:ee:ff\n")
+	f.WriteString("1564500100 aa:bb:cc:dd:ee:00 192.168.1.11 * *\n")
+	f.WriteString("1564500100 aa:bb:cc:dd:ee:01 192.168.1 broken *\n")
+	f.Close()
+
+	resetMetrics()
+	hosts := readDnsmasqLeases(f.Name(), true, time.Unix(1564500000, 0))
+	assert.Equal(t, hostList{
+		{hostname: "myhost", ip: net.ParseIP("192.168.1.10")},
+	}, hosts)
+	assert.Equal(t, float64(1), hostsParsed.get(f.Name()))
+	assert.Equal(t, float64(1), hostsSkipped.get(f.Name()))
+}
+
+const testDhcpdLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.3.5
+
+# authoring-byte-order entry is generated, DO NOT DELETE
+authoring-byte-order little-endian;
+
+server-duid "\000\001\000\001\037\304\260\2324\027\353\332x\251";
+
+lease 192.168.1.10 {
+  starts 2 2019/07/30 10:00:00;
+  ends 2 2019/07/30 22:00:00;
+  cltt 2 2019/07/30 10:00:00;
+  binding state active;
+  next binding state free;
+  rewind binding state free;
+  hardware ethernet aa:bb:cc:dd:ee:ff;
+  uid "\001\252\273\314\335\356\377";
+  client-hostname "laptop";
+}
+lease 192.168.1.11 {
+  starts 2 2019/07/30 01:00:00;
+  ends 2 2019/07/30 02:00:00;
+  binding state active;
+  client-hostname "expired";
+}
+lease 192.168.1.12 {
+  ends never;
+  binding state active;
+  client-hostname "Printer";
+  set vendor-class-identifier = "MSFT 5.0";
+}
+lease 192.168.1.13 {
+  ends epoch 1564531200; # Wed Jul 31 00:00:00 2019
+  binding state free;
+  client-hostname "released";
+}
+lease 192.168.1.14 {
+  ends epoch 1564531200; # Wed Jul 31 00:00:00 2019
+  binding state active;
+}
+lease 192.168.1 {
+  binding state active;
+  client-hostname "broken";
+}
+lease 192.168.1.10 {
+  starts 2 2019/07/30 11:00:00;
+  ends 2 2019/07/30 23:00:00;
+  binding state active;
+  client-hostname "phone";
+}
+failover peer "dhcp-failover" state {
+  my state normal at 2 2019/07/30 10:00:00;
+}
+`
+
+func TestParseDhcpdLeases(t *testing.T) {
+	hosts, skipped, err := parseDhcpdLeases(testDhcpdLeases, time.Date(2019, 7, 30, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{
+		{hostname: "phone", ip: net.ParseIP("192.168.1.10")},
+		{hostname: "Printer", ip: net.ParseIP("192.168.1.12")},
+	}, hosts)
+	assert.Equal(t, 1, skipped)
+
+	_, _, err = parseDhcpdLeases(`lease 192.168.1.10 { binding state active;`, time.Now())
+	assert.NotNil(t, err)
+	_, _, err = parseDhcpdLeases(`lease 192.168.1.10 { client-hostname "foo; }`, time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestReadDhcpdLeases(t *testing.T) {
+	f, err := ioutil.TempFile("", "dhcpd.leases")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(testDhcpdLeases)
+	f.Close()
+
+	resetMetrics()
+	hosts := readDhcpdLeases(f.Name(), time.Date(2019, 7, 30, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, hostList{
+		{hostname: "phone", ip: net.ParseIP("192.168.1.10")},
+		{hostname: "printer", ip: net.ParseIP("192.168.1.12")},
+	}, hosts)
+	assert.Equal(t, float64(2), hostsParsed.get(f.Name()))
+	assert.Equal(t, float64(1), hostsSkipped.get(f.Name()))
+}
+
+const testKeaLeases = `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.10,aa:bb:cc:dd:ee:ff,,3600,1564491600,1,0,0,laptop,0,
+192.168.1.11,aa:bb:cc:dd:ee:00,,3600,1564491600,1,0,0,declined,1,
+192.168.1.12,aa:bb:cc:dd:ee:01,,3600,1564480000,1,0,0,expired,0,
+192.168.1.13,aa:bb:cc:dd:ee:02,,3600,1564491600,1,0,0,,0,
+192.168.1.10,aa:bb:cc:dd:ee:ff,,3600,1564495200,1,0,0,phone.example.com.,0,
+192.168.1.14,aa:bb:cc:dd:ee:03,,3600,1564491600,1,0,0,reclaimed,2,
+192.168.1.15,aa:bb:cc:dd:ee:04,,3600,1564491600,1,0,0,short
+192.168.1.16,aa:bb:cc:dd:ee:05,,3600,soon,1,0,0,broken,0,
+`
+
+const testKea6Leases = `address,duid,valid_lifetime,expire,subnet_id,pref_lifetime,lease_type,iaid,prefix_len,fqdn_fwd,fqdn_rev,hostname,hwaddr,state,user_context
+2001:db8::10,00:01:00:01,3600,1564491600,1,3000,0,1,128,0,0,server,,0,
+`
+
+func TestParseKeaLeases(t *testing.T) {
+	now := time.Unix(1564488000, 0)
+	hosts, skipped, err := parseKeaLeases(strings.NewReader(testKeaLeases), now)
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{
+		{hostname: "phone.example.com.", ip: net.ParseIP("192.168.1.10")},
+	}, hosts)
+	assert.Equal(t, 2, skipped)
+
+	hosts, skipped, err = parseKeaLeases(strings.NewReader(testKea6Leases), now)
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{
+		{hostname: "server", ip: net.ParseIP("2001:db8::10")},
+	}, hosts)
+	assert.Equal(t, 0, skipped)
+
+	_, _, err = parseKeaLeases(strings.NewReader("address,hwaddr\n"), now)
+	assert.NotNil(t, err)
+}
+
+func TestReadKeaLeases(t *testing.T) {
+	f, err := ioutil.TempFile("", "kea-leases")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(testKeaLeases)
+	f.Close()
+
+	resetMetrics()
+	hosts := readKeaLeases(f.Name(), time.Unix(1564488000, 0))
+	assert.Equal(t, hostList{
+		{hostname: "phone.example.com", ip: net.ParseIP("192.168.1.10")},
+	}, hosts)
+	assert.Equal(t, float64(1), hostsParsed.get(f.Name()))
+	assert.Equal(t, float64(2), hostsSkipped.get(f.Name()))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	logrus_syslog "github.com/Sirupsen/logrus/hooks/syslog"
+)
+
+// logSetup is the part of the logging setup that changes when the daemon
+// reloads its configuration.  logrus reads the fields of a Logger without
+// locking, so they can't be changed while other goroutines are logging.
+// Instead the logger is pointed at the wrappers below once, and they read the
+// current setup under a lock.
+type logSetup struct {
+	out       io.Writer
+	level     logrus.Level
+	formatter logrus.Formatter
+	// hook is only set when logging to syslog
+	hook *logrus_syslog.SyslogHook
+}
+
+var (
+	logMu sync.RWMutex
+	// The same defaults as logrus.New
+	currentLog = logSetup{
+		out:       os.Stderr,
+		level:     logrus.InfoLevel,
+		formatter: new(logrus.TextFormatter),
+	}
+)
+
+// newLogger returns a logger that logs with whatever setLogSetup last set up.
+// Its own level lets everything through, and the level is checked by the
+// wrappers instead.
+func newLogger() *logrus.Logger {
+	l := logrus.New()
+	l.Out = logOutput{}
+	l.Formatter = logFormatter{}
+	l.Hooks.Add(logHook{})
+	l.Level = logrus.DebugLevel
+	return l
+}
+
+// setLogSetup switches logging over to s, returning the previous setup.
+func setLogSetup(s logSetup) logSetup {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	old := currentLog
+	currentLog = s
+	return old
+}
+
+func currentLogSetup() logSetup {
+	logMu.RLock()
+	defer logMu.RUnlock()
+
+	return currentLog
+}
+
+// debugLogging returns true if debug messages are being logged, to skip work
+// that only produces debug messages.
+func debugLogging() bool {
+	return currentLogSetup().level >= logrus.DebugLevel
+}
+
+type logOutput struct{}
+
+func (logOutput) Write(p []byte) (int, error) {
+	return currentLogSetup().out.Write(p)
+}
+
+// logFormatter formats entries with the current formatter.  Entries below the
+// current level are formatted as nothing, which logrus then writes out as
+// nothing.
+type logFormatter struct{}
+
+func (logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	s := currentLogSetup()
+	if entry.Level > s.level {
+		return nil, nil
+	}
+	return s.formatter.Format(entry)
+}
+
+// logHook passes entries at the current level on to the syslog hook, if there
+// is one.
+type logHook struct{}
+
+func (logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (logHook) Fire(entry *logrus.Entry) error {
+	s := currentLogSetup()
+	if s.hook == nil || entry.Level > s.level {
+		return nil
+	}
+	return s.hook.Fire(entry)
+}
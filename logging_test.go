@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSetup(t *testing.T) {
+	var buf bytes.Buffer
+	old := setLogSetup(logSetup{
+		out:       &buf,
+		level:     logrus.InfoLevel,
+		formatter: &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true},
+	})
+	defer setLogSetup(old)
+
+	log.Debug("hidden")
+	log.Info("shown")
+	assert.Equal(t, "level=info msg=shown \n", buf.String())
+	assert.False(t, debugLogging())
+
+	s := currentLogSetup()
+	s.level = logrus.DebugLevel
+	setLogSetup(s)
+	buf.Reset()
+	log.Debug("shown")
+	assert.Equal(t, "level=debug msg=shown \n", buf.String())
+	assert.True(t, debugLogging())
+}
+
+func TestConfigureLogging(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	var buf bytes.Buffer
+	old := setLogSetup(logSetup{out: &buf, level: logrus.InfoLevel, formatter: new(logrus.TextFormatter)})
+	defer setLogSetup(old)
+
+	// A bad reload keeps logging as it was
+	opts.Debug = true
+	opts.Syslog = true
+	opts.SyslogFacility = "nonexistent"
+	assert.NotNil(t, configureLogging())
+	assert.Equal(t, &buf, currentLogSetup().out)
+	assert.Equal(t, logrus.InfoLevel, currentLogSetup().level)
+
+	// Reconfiguring while other goroutines are logging is safe
+	opts.Syslog = false
+	opts.Debug = false
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				log.Debug("logging during a reload")
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, configureLogging())
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, logrus.InfoLevel, currentLogSetup().level)
+}
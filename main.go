@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log/syslog"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -19,26 +20,55 @@ import (
 // Makefile is used.
 var version = "unknown"
 
-var log = logrus.New()
-
-var opts struct {
-	Mode           string        `short:"m" long:"mode" description:"Operating mode" default:"daemon" choice:"daemon" choice:"oneshot"`
-	File           string        `short:"f" long:"file" description:"Input file in /etc/hosts format" default:"/etc/hosts" value-name:"HOSTFILE"`
-	Networks       []CIDRNet     `long:"network" description:"Filter by CIDR network" value-name:"x.x.x.x/len"`
-	Domain         string        `short:"d" long:"domain" description:"Domain to update records in"`
-	Interval       time.Duration `short:"i" long:"interval" description:"Seconds between scheduled resync times." default:"15m"`
-	TTL            int64         `long:"ttl" description:"TTL to use for Route 53 records" default:"3600"`
-	NoQualifyHosts bool          `long:"no-qualify-hosts" description:"Don't force domain to be added to end of hosts"`
-	ExcludeHosts   []string      `long:"exclude-host" description:"Exclude one or more hosts from being synced"`
-	NoWait         bool          `long:"no-wait" description:"Don't wait for Route 53 to finish update"`
-	Syslog         bool          `long:"syslog" description:"Send logging to syslog in addition to stdout"`
-	SyslogFacility string        `long:"syslog-facility" description:"Syslog facility to log under" default:"user"`
-	SyslogOnly     bool          `long:"syslog-only" description:"Send logging *only* to syslog"`
-	Debug          bool          `long:"debug" description:"Enable debug logging"`
-	Version        bool          `long:"version" description:"Print version number and exit"`
+var log = newLogger()
+
+// options holds every command line option.
+type options struct {
+	Config               string        `long:"config" description:"YAML file to read options from, command line options take precedence" value-name:"FILE"`
+	Mode                 string        `short:"m" long:"mode" description:"Operating mode" default:"daemon" choice:"daemon" choice:"oneshot" choice:"plan" choice:"apply" choice:"validate-config"`
+	File                 string        `short:"f" long:"file" description:"Input file, in /etc/hosts format by default" default:"/etc/hosts" value-name:"HOSTFILE"`
+	InputFormat          string        `long:"input-format" description:"Format of the input file" default:"hosts" choice:"hosts" choice:"dnsmasq-leases" choice:"isc-dhcpd-leases" choice:"kea-leases"`
+	SkipExpiredLeases    bool          `long:"skip-expired-leases" description:"Ignore dnsmasq leases that have already expired"`
+	Networks             []CIDRNet     `long:"network" description:"Filter by CIDR network" value-name:"x.x.x.x/len"`
+	Domain               string        `short:"d" long:"domain" description:"Domain to update records in"`
+	Zones                []string      `long:"zone" description:"Another zone that hosts can be synced to with a zone= annotation" value-name:"DOMAIN"`
+	Interval             time.Duration `short:"i" long:"interval" description:"Seconds between scheduled resync times." default:"15m"`
+	Debounce             time.Duration `long:"debounce" description:"Wait for the input file to stop changing for this long before syncing" default:"2s"`
+	DebounceMax          time.Duration `long:"debounce-max" description:"Longest to hold off syncing while the input file keeps changing" default:"30s"`
+	Watch                string        `long:"watch" description:"How to watch the input file for changes" choice:"auto" choice:"notify" choice:"poll" default:"auto"`
+	PollInterval         time.Duration `long:"poll-interval" description:"Time between checks of the input file when polling for changes" default:"5s"`
+	HTTPListen           string        `long:"http-listen" description:"Address to serve metrics and health checks on over HTTP in daemon mode" value-name:"[HOST]:PORT"`
+	ControlListen        string        `long:"control-listen" description:"Address to serve the control API on over HTTP in daemon mode, or unix:PATH for a Unix socket" value-name:"[HOST]:PORT|unix:PATH"`
+	HealthIntervals      int           `long:"health-intervals" description:"Intervals a job can go without running before it is unhealthy, or without syncing before it is not ready" default:"3"`
+	TTL                  int64         `long:"ttl" description:"Default TTL to use for DNS records" default:"3600"`
+	NoQualifyHosts       bool          `long:"no-qualify-hosts" description:"Don't force domain to be added to end of hosts"`
+	ExcludeHosts         []string      `long:"exclude-host" description:"Exclude one or more hosts from being synced"`
+	Jobs                 []string      `long:"job" description:"Sync another input file and domain, overriding the top level options" value-name:"file=FILE,domain=DOMAIN,network=x.x.x.x/len,..."`
+	MultiValue           bool          `long:"multi-value" description:"Publish every address for a hostname as a multi-value record set"`
+	AliasCNAMEs          bool          `long:"alias-cnames" description:"Publish host aliases as CNAME records"`
+	PTRRecords           bool          `long:"ptr-records" description:"Manage PTR records in the matching reverse zones"`
+	OwnerID              string        `long:"owner-id" description:"Only change records owned by this ID, tracked with TXT records" value-name:"ID"`
+	AdoptRecords         bool          `long:"adopt-records" description:"Take ownership of existing records that have no owner"`
+	Provider             string        `long:"provider" description:"DNS provider to sync records to" default:"route53" choice:"route53" choice:"rfc2136" choice:"zonefile"`
+	RFC2136Server        string        `long:"rfc2136-server" description:"DNS server to send dynamic updates to" value-name:"HOST[:PORT]"`
+	RFC2136TSIGKey       string        `long:"rfc2136-tsig-key" description:"Name of the TSIG key used to sign dynamic updates" value-name:"KEYNAME"`
+	RFC2136TSIGSecret    string        `long:"rfc2136-tsig-secret" description:"Base64 encoded TSIG secret" env:"RFC2136_TSIG_SECRET" value-name:"SECRET"`
+	RFC2136TSIGAlgorithm string        `long:"rfc2136-tsig-algorithm" description:"TSIG algorithm" default:"hmac-sha256" choice:"hmac-md5.sig-alg.reg.int" choice:"hmac-sha1" choice:"hmac-sha256" choice:"hmac-sha512"`
+	ZoneFile             string        `long:"zone-file" description:"Zone file to write with the zonefile provider" value-name:"FILE"`
+	ZoneFileNS           string        `long:"zone-file-ns" description:"Name server for the SOA and NS records of a new zone file" value-name:"HOSTNAME"`
+	NoWait               bool          `long:"no-wait" description:"Don't wait for the DNS provider to finish update"`
+	PlanFile             string        `long:"plan-file" description:"File to save the plan to in plan mode, or read it from in apply mode" value-name:"PLANFILE"`
+	Syslog               bool          `long:"syslog" description:"Send logging to syslog in addition to stdout"`
+	SyslogFacility       string        `long:"syslog-facility" description:"Syslog facility to log under" default:"user"`
+	SyslogOnly           bool          `long:"syslog-only" description:"Send logging *only* to syslog"`
+	Debug                bool          `long:"debug" description:"Enable debug logging"`
+	Version              bool          `long:"version" description:"Print version number and exit"`
 }
 
-func facilityStringToInt(facility string) syslog.Priority {
+var opts options
+
+// lookupFacility returns the syslog priority for a facility name.
+func lookupFacility(facility string) (syslog.Priority, error) {
 	lookup := map[string]syslog.Priority{
 		"kern":     syslog.LOG_KERN,
 		"user":     syslog.LOG_USER,
@@ -65,77 +95,151 @@ func facilityStringToInt(facility string) syslog.Priority {
 	p, ok := lookup[facility]
 
 	if !ok {
-		keys := make([]string, len(lookup))
+		keys := make([]string, 0, len(lookup))
 		for k := range lookup {
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
 
-		log.Errorf("\"%v\" is not a valid syslog facility", facility)
-		log.Fatalln("Valid facilities are:", strings.Join(keys, " "))
+		return 0, fmt.Errorf("\"%v\" is not a valid syslog facility.  Valid facilities are: %v",
+			facility, strings.Join(keys, " "))
 	}
 
-	return p
+	return p, nil
 }
 
 func parseOpts() {
-	parser := flags.NewParser(&opts, flags.Default)
-
-	if _, err := parser.Parse(); err != nil {
-		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
-			os.Exit(0)
+	o, jobs, err := loadOpts(os.Args[1:], flags.Default)
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok {
+			// go-flags has already printed the error or help text
+			if flagsErr.Type == flags.ErrHelp {
+				os.Exit(0)
+			}
 		} else {
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, err)
 		}
+		os.Exit(1)
 	}
+	opts, syncJobs = o, jobs
 
 	if opts.Version {
 		fmt.Println("version: ", version)
 		os.Exit(0)
 	}
 
-	if opts.Domain == "" {
-		fmt.Fprintln(os.Stderr, "domain name must be specified (-d or --domain)")
-		os.Exit(1)
+	if opts.Mode == "validate-config" {
+		fmt.Println("Configuration is valid")
+		os.Exit(0)
 	}
+}
 
-	if len(opts.Networks) == 0 {
-		fmt.Fprintln(os.Stderr, "one or more networks must be provided (--network)")
-		os.Exit(1)
+// loadOpts parses args, along with the config file they name, and checks the
+// options.  It returns the options and the jobs they describe, without
+// changing the ones currently in use.
+func loadOpts(args []string, parserOpts flags.Options) (options, []syncJob, error) {
+	args, err := withConfigFile(args)
+	if err != nil {
+		return options{}, nil, err
 	}
 
-	// Accept trailing dot, but ignore it for consistency sake
-	if strings.HasSuffix(opts.Domain, ".") {
-		opts.Domain = opts.Domain[:len(opts.Domain)-1]
+	var o options
+	parser := flags.NewParser(&o, parserOpts)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return options{}, nil, err
+	}
+
+	if o.Version {
+		return o, nil, nil
 	}
+
+	if _, err := lookupFacility(o.SyslogFacility); err != nil {
+		return options{}, nil, err
+	}
+
+	if o.Mode == "apply" {
+		// Everything else needed comes from the plan file
+		if o.PlanFile == "" {
+			return options{}, nil, fmt.Errorf("plan file must be specified in apply mode (--plan-file)")
+		}
+		return o, nil, nil
+	}
+
+	jobs, err := parseJobs(o)
+	if err != nil {
+		return options{}, nil, err
+	}
+
+	if o.Provider == "zonefile" && len(jobs) > 1 {
+		return options{}, nil, fmt.Errorf("the zonefile provider only supports a single job")
+	}
+	if o.Provider == "zonefile" && len(jobs[0].zones) > 0 {
+		return options{}, nil, fmt.Errorf("the zonefile provider only supports a single zone (--zone)")
+	}
+
+	if strings.ContainsAny(o.OwnerID, "\",= ") {
+		return options{}, nil, fmt.Errorf("owner ID cannot contain quotes, commas, equals signs or spaces")
+	}
+
+	if o.AdoptRecords && o.OwnerID == "" {
+		return options{}, nil, fmt.Errorf("owner ID must be given to adopt records (--owner-id)")
+	}
+
+	if o.Debounce < 0 || o.DebounceMax < 0 {
+		return options{}, nil, fmt.Errorf("debounce times cannot be negative")
+	}
+
+	if o.PollInterval <= 0 {
+		return options{}, nil, fmt.Errorf("poll interval must be positive")
+	}
+
+	if o.HealthIntervals < 2 {
+		return options{}, nil, fmt.Errorf("health intervals must be at least 2")
+	}
+
+	return o, jobs, nil
 }
 
-func configureLogging() {
+// configureLogging sets up logging from the options.  It starts from scratch
+// each time, since the daemon runs it again when it reloads its
+// configuration.  If syslog can't be set up, an error is returned and the
+// current setup is kept.
+func configureLogging() error {
+	// logrus defaults to stderr, but stdout is more conventional
+	s := logSetup{
+		out:       os.Stdout,
+		level:     logrus.InfoLevel,
+		formatter: new(logrus.TextFormatter),
+	}
 	if opts.SyslogOnly {
-		log.Out = ioutil.Discard
-	} else {
-		// logrus defaults to stderr, but stdout is more conventional
-		log.Out = os.Stdout
+		s.out = ioutil.Discard
 	}
-
 	if opts.Debug {
-		log.Level = logrus.DebugLevel
+		s.level = logrus.DebugLevel
 	}
 
 	if opts.Syslog || opts.SyslogOnly {
-		facility := facilityStringToInt(opts.SyslogFacility)
-		log.Info("Disabling color for syslog")
-		tf := &logrus.TextFormatter{DisableColors: true}
-		log.Formatter = tf
-
+		facility, err := lookupFacility(opts.SyslogFacility)
+		if err != nil {
+			return err
+		}
 		hook, err := logrus_syslog.NewSyslogHook("", "", facility, "sync-hosts-to-route53")
-		if err == nil {
-			log.Hooks.Add(hook)
-		} else {
-			log.Fatal("Cannot initialize syslog: ", err)
+		if err != nil {
+			return errors.Wrap(err, "Cannot initialize syslog")
 		}
+		s.hook = hook
+		s.formatter = &logrus.TextFormatter{DisableColors: true}
+	}
+
+	old := setLogSetup(s)
+	if old.hook != nil {
+		old.hook.Writer.Close()
+	}
+	if s.hook != nil {
+		log.Info("Disabling color for syslog")
 	}
 
+	return nil
 }
 
 func canonifyHostname(hostname string) string {
@@ -151,33 +255,35 @@ func canonifyHostname(hostname string) string {
 // compareHosts takes the contents of the local /etc/hosts file and the
 // Route 53 hosts that should be compared and produces two arrays.  The first
 // array is a list of Route 53 records that need to be updated, and the second
-// array is a list of Route 53 records that should be deleted.
+// array is a list of Route 53 records that should be deleted.  Hosts with the
+// same name and type form a single record set, and sets are compared as
+// unordered collections of values.  If any value in a set differs, every host
+// in the set is included in the result.
 func compareHosts(hosts hostList, r53hosts hostList) (hostList, hostList) {
-	// Build index on name, we'll delete entries out of here a we match them
+	// Build index on name and type, we'll delete entries out of here a we match them
 	// against /etc/hosts entries.  The remaining entries aren't present
 	// locally anymore and will need to be deleted.
-	rhByName := map[string]hostEntry{}
-	for _, rh := range r53hosts {
-		rhByName[rh.hostname] = rh
-	}
+	rhByName, _ := groupByKey(r53hosts)
+	hByName, keys := groupByKey(hosts)
 
 	toUpdate := hostList{}
 	// Find existing hosts
-	for _, h := range hosts {
-		rh, ok := rhByName[h.hostname]
+	for _, key := range keys {
+		rhs, ok := rhByName[key]
 		if ok {
-			delete(rhByName, h.hostname)
-			if !h.ip.Equal(rh.ip) {
-				toUpdate = append(toUpdate, h)
+			delete(rhByName, key)
+			if !reflect.DeepEqual(hByName[key].values(), rhs.values()) ||
+				hByName[key].ttl(0) != rhs.ttl(0) {
+				toUpdate = append(toUpdate, hByName[key]...)
 			}
 		} else {
-			toUpdate = append(toUpdate, h)
+			toUpdate = append(toUpdate, hByName[key]...)
 		}
 	}
 
 	toDelete := make(hostList, 0, len(rhByName))
-	for _, rh := range rhByName {
-		toDelete = append(toDelete, rh)
+	for _, rhs := range rhByName {
+		toDelete = append(toDelete, rhs...)
 	}
 
 	return toUpdate, toDelete
@@ -193,12 +299,12 @@ func removeDupes(hosts hostList) hostList {
 	dupCount := 0
 	result := make(hostList, 0, len(hosts))
 	for _, h := range hosts {
-		if _, ok := found[h.hostname]; ok {
+		if _, ok := found[h.key()]; ok {
 			log.Warnf("Duplicate hostname found in hosts, ignoring (%v/%v)",
-				h.hostname, h.ip.String())
+				h.hostname, h.value())
 			dupCount++
 		} else {
-			found[h.hostname] = true
+			found[h.key()] = true
 			result = append(result, h)
 		}
 	}
@@ -206,6 +312,57 @@ func removeDupes(hosts hostList) hostList {
 	return result
 }
 
+// removeDupeValues is like removeDupes, but only removes hosts that have the
+// same name, type and value, so that a name can have several values.  CNAMEs
+// are the exception, since a CNAME record set can only have one value.
+func removeDupeValues(hosts hostList) hostList {
+	found := make(map[string]bool, len(hosts))
+	cnames := map[string]bool{}
+	sort.Sort(hosts)
+
+	result := make(hostList, 0, len(hosts))
+	for _, h := range hosts {
+		if found[h.key()+"/"+h.value()] {
+			continue
+		}
+		if h.recordType() == "CNAME" {
+			if cnames[h.key()] {
+				log.Warnf("Duplicate alias found in hosts, ignoring (%v/%v)",
+					h.hostname, h.value())
+				continue
+			}
+			cnames[h.key()] = true
+		}
+		found[h.key()+"/"+h.value()] = true
+		result = append(result, h)
+	}
+
+	return result
+}
+
+// removeMultiValueSets drops record sets with more than one value, which are
+// only managed with --multi-value.  The keys of the dropped sets are returned
+// too.
+func removeMultiValueSets(hosts hostList) (hostList, map[string]bool) {
+	groups, _ := groupByKey(hosts)
+
+	result := make(hostList, 0, len(hosts))
+	removed := map[string]bool{}
+	for _, h := range hosts {
+		if n := len(groups[h.key()]); n > 1 {
+			if !removed[h.key()] {
+				log.Warnf("%v has too many resource records (%d), ignoring record",
+					h.hostname, n)
+			}
+			removed[h.key()] = true
+			continue
+		}
+		result = append(result, h)
+	}
+
+	return result, removed
+}
+
 func removeExcludedHosts(hosts hostList, excludeList []string) hostList {
 	hl := make(hostList, 0, len(hosts))
 
@@ -225,43 +382,222 @@ func removeExcludedHosts(hosts hostList, excludeList []string) hostList {
 	return hl
 }
 
-func runOnce() error {
-	hosts := readHosts(opts.File)
-	hosts = filterHostsByNetwork(hosts, opts.Networks)
+// computePlan reads the local hosts file and the records currently held by
+// the DNS provider and works out which changes are needed to bring each zone
+// in sync.
+func computePlan(p dnsProvider, job syncJob) (syncPlans, error) {
+	hosts := readInput(job.file)
+	hosts = setDefaultTTL(hosts, job.ttl)
+	hosts = filterHostsByNetwork(hosts, job.networks)
+
+	plans := syncPlans{}
+	forwardHosts, forwardZoneHosts := hostList{}, hostList{}
+	zones, domains := hostsByZone(hosts, job.domain, job.zones)
+	for _, domain := range domains {
+		plan, hosts, zoneHosts, err := computeZonePlan(p, job, domain, zones[domain])
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+		forwardHosts = append(forwardHosts, hosts...)
+		forwardZoneHosts = append(forwardZoneHosts, zoneHosts...)
+	}
+
+	if opts.PTRRecords {
+		ptrPlans, err := computePTRPlans(p, job, forwardHosts, forwardZoneHosts)
+		if err != nil {
+			log.Warn(errors.Wrap(err, "error when computing PTR records"))
+			return nil, err
+		}
+		plans = append(plans, ptrPlans...)
+	}
+
+	return plans, nil
+}
+
+// computeZonePlan works out the changes needed to sync hosts to a single
+// forward zone.  Along with the plan it returns the records that should exist
+// in the zone and the managed records that exist now.
+func computeZonePlan(p dnsProvider, job syncJob, domain string, hosts hostList) (syncPlan, hostList, hostList, error) {
+	if !opts.NoQualifyHosts {
+		hosts = qualifyHosts(hosts, domain)
+	}
+	hosts, skipped := removeSkippedHosts(hosts)
+	excludes := append(append([]string{}, job.excludes...), skipped...)
+
+	// Aliases are published for every host with --alias-cnames, and only for
+	// hosts annotated with aliases=cname otherwise.
+	listed := aliasCNAMEs(hosts)
 	if !opts.NoQualifyHosts {
-		hosts = qualifyHosts(hosts, opts.Domain)
+		listed = qualifyHosts(listed, domain)
+	}
+	cnames := listed
+	if !opts.AliasCNAMEs {
+		annotated := map[string]bool{}
+		for _, h := range hosts {
+			if h.cnameAliases {
+				annotated[h.hostname] = true
+			}
+		}
+		cnames = hostList{}
+		for _, c := range listed {
+			if annotated[c.target] {
+				cnames = append(cnames, c)
+			}
+		}
+	}
+	hosts = append(hosts, removeConflictingCNAMEs(cnames, hosts)...)
+	if opts.MultiValue {
+		hosts = removeDupeValues(hosts)
+	} else {
+		hosts = removeDupes(hosts)
 	}
-	hosts = removeDupes(hosts)
-	hosts = removeExcludedHosts(hosts, opts.ExcludeHosts)
+	hosts = removeExcludedHosts(hosts, excludes)
 
-	r53 := newRoute53()
-	r53Hosts, err := r53.getHosts(opts.Domain)
+	allZoneHosts, err := p.getHosts(domain)
 	if err != nil {
 		log.Warn(errors.Wrap(err, "error when retrieving zones"))
+		return syncPlan{}, nil, nil, err
+	}
+	if !opts.MultiValue {
+		// Hosts matching a multi-value set are skipped too, since updating
+		// them would replace the whole set
+		var sets map[string]bool
+		allZoneHosts, sets = removeMultiValueSets(allZoneHosts)
+		single := make(hostList, 0, len(hosts))
+		for _, h := range hosts {
+			if sets[h.key()] {
+				log.Warnf("%v (%v) is a multi-value record set, skipping without --multi-value",
+					h.hostname, h.recordType())
+				continue
+			}
+			single = append(single, h)
+		}
+		hosts = single
+	}
+	zoneHosts := filterHostsByNetwork(allZoneHosts, job.networks)
+	if opts.AliasCNAMEs {
+		// CNAMEs have no address to filter on, so they are managed if they
+		// point at a host that is.
+		managed := append(hostList{}, hosts...)
+		managed = append(managed, zoneHosts...)
+		zoneHosts = append(zoneHosts, filterCNAMEsByTarget(allZoneHosts, managed)...)
+	} else {
+		// Only the CNAMEs for aliases that are still listed are managed, so
+		// that they are deleted when an aliases=cname annotation is removed,
+		// without touching any others.
+		zoneHosts = append(zoneHosts, filterListedCNAMEs(allZoneHosts, listed)...)
+	}
+	zoneHosts = removeExcludedHosts(zoneHosts, excludes)
+	if opts.OwnerID != "" {
+		hosts, zoneHosts = applyOwnership(hosts, zoneHosts, allZoneHosts, opts.OwnerID, opts.AdoptRecords)
+	}
+
+	toUpdate, toDelete := compareHosts(hosts, zoneHosts)
+	plan := syncPlan{
+		domain:   domain,
+		ttl:      job.ttl,
+		toUpdate: toUpdate,
+		toDelete: toDelete,
+		current:  zoneHosts,
+		zone:     allZoneHosts,
+	}
+
+	return plan, hosts, zoneHosts, nil
+}
+
+func runOnce(job syncJob) (err error) {
+	status := syncStatus{started: time.Now()}
+	defer func() {
+		status.finished = time.Now()
+		status.err = err
+		recordStatus(job, status)
+		recordSyncMetrics(job, status)
+	}()
+
+	p, err := newProvider(opts.Provider)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	plans, err := computePlan(p, job)
+	if err != nil {
 		return err
 	}
-	r53Hosts = filterHostsByNetwork(r53Hosts, opts.Networks)
-	r53Hosts = removeExcludedHosts(r53Hosts, opts.ExcludeHosts)
+	status.plans = plans
+
+	if !plans.hasChanges() {
+		log.Infof("No changes needed for %v.  Everything in sync.", job)
+		return nil
+	}
+
+	if ct, ok := p.(changeTracker); ok {
+		defer func() { status.pending = ct.pendingChanges() }()
+	}
 
-	toUpdate, toDelete := compareHosts(hosts, r53Hosts)
-	if len(toUpdate) > 0 || len(toDelete) > 0 {
-		if err := r53.sync(opts.Domain, opts.TTL, !opts.NoWait, toUpdate, toDelete); err != nil {
-			log.Warn(errors.Wrap(err, "Could not sync records to Route 53"))
+	for _, plan := range plans {
+		if !plan.hasChanges() {
+			continue
+		}
+		if err := applyChanges(p, plan.domain, plan.ttl, plan.toUpdate, plan.toDelete); err != nil {
+			log.Warn(errors.Wrapf(err, "Could not sync records in %v", plan.domain))
 			return err
 		}
-	} else {
-		log.Info("No changes needed.  Everything in sync.")
 	}
 
 	return nil
 }
 
+// runPlan prints the changes that would be made without applying them.  The
+// return value is the exit code: 0 if in sync, 2 if changes are pending and
+// 1 if the plan could not be computed.
+func runPlan() int {
+	p, err := newProvider(opts.Provider)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	plans := syncPlans{}
+	for _, job := range syncJobs {
+		jobPlans, err := computePlan(p, job)
+		if err != nil {
+			return 1
+		}
+		plans = append(plans, jobPlans...)
+	}
+
+	renderPlan(os.Stdout, plans)
+	if opts.PlanFile != "" {
+		if err := writePlanFile(opts.PlanFile, newPlanFile(plans)); err != nil {
+			log.Error(errors.Wrap(err, "Could not save plan"))
+			return 1
+		}
+		log.Info("Plan saved to ", opts.PlanFile)
+	}
+
+	if plans.hasChanges() {
+		return 2
+	}
+	return 0
+}
+
 func main() {
 	parseOpts()
-	configureLogging()
-	if opts.Mode == "oneshot" {
-		runOnce()
-	} else {
-		daemon(opts.Interval, opts.File)
+	if err := configureLogging(); err != nil {
+		log.Fatal(err)
+	}
+	switch opts.Mode {
+	case "oneshot":
+		for _, job := range syncJobs {
+			runOnce(job)
+		}
+	case "plan":
+		os.Exit(runPlan())
+	case "apply":
+		os.Exit(runApply())
+	default:
+		daemon()
 	}
 }
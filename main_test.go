@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"sort"
 	"testing"
 
+	flags "github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -60,6 +65,122 @@ func TestCompareHosts(t *testing.T) {
 				{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
 			},
 		},
+		{"dual-stack-add-aaaa",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+			},
+			hostList{},
+		},
+		{"dual-stack-remove-aaaa",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+			},
+			hostList{},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+			},
+		},
+		{"update-cname",
+			hostList{
+				{hostname: "www.test.com", rtype: "CNAME", target: "test2.test.com"},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+				{hostname: "mail.test.com", rtype: "CNAME", target: "test1.test.com"},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "www.test.com", rtype: "CNAME", target: "test2.test.com"},
+			},
+			hostList{
+				{hostname: "mail.test.com", rtype: "CNAME", target: "test1.test.com"},
+			},
+		},
+		{"update-aaaa",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::2")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::2")},
+			},
+			hostList{},
+		},
+		{"update-ttl",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 60},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 3600},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 60},
+			},
+			hostList{},
+		},
+		{"same-ttl",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 60},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 60},
+			},
+			hostList{},
+			hostList{},
+		},
+		{"multi-value-noop",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+			},
+			hostList{},
+			hostList{},
+		},
+		{"multi-value-add-value",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+			},
+			hostList{},
+		},
+		{"multi-value-remove-value",
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+			},
+			hostList{
+				{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			},
+			hostList{},
+		},
 	}
 
 	for _, c := range cases {
@@ -103,3 +224,172 @@ func TestRemoveExcludedHosts(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveDupes(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+	}
+	expected := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+	}
+
+	assert.Equal(t, expected, removeDupes(hosts))
+}
+
+func TestRemoveDupeValues(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+	expected := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+
+	assert.Equal(t, expected, removeDupeValues(hosts))
+
+	// An alias on two lines can still only be a single CNAME
+	cnames := hostList{
+		{hostname: "www.test.com", rtype: "CNAME", target: "web2.test.com"},
+		{hostname: "www.test.com", rtype: "CNAME", target: "web1.test.com"},
+		{hostname: "www.test.com", rtype: "CNAME", target: "web1.test.com"},
+	}
+	assert.Equal(t, hostList{
+		{hostname: "www.test.com", rtype: "CNAME", target: "web1.test.com"},
+	}, removeDupeValues(cnames))
+}
+
+func TestRemoveMultiValueSets(t *testing.T) {
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6")},
+	}
+	expected := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6")},
+	}
+
+	result, removed := removeMultiValueSets(hosts)
+	assert.Equal(t, expected, result)
+	assert.Equal(t, map[string]bool{"test1.test.com/A": true}, removed)
+}
+
+func TestComputePlanAnnotations(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("10.0.0.1 test1 mail\n")
+	f.WriteString("10.0.0.2 test2 # r53: skip\n")
+	f.WriteString("10.0.0.3 test3 www # r53: aliases=cname ttl=60\n")
+	f.WriteString("10.0.0.4 test4 # r53: zone=dmz.test.com\n")
+	f.WriteString("10.0.0.5 test5 # r53: zone=other.test.com\n")
+	f.Close()
+
+	var n CIDRNet
+	n.UnmarshalFlag("10.0.0.0/8")
+	job := syncJob{file: f.Name(), domain: "test.com", zones: []string{"dmz.test.com"},
+		networks: []CIDRNet{n}, ttl: 3600}
+
+	p := &fakeZoneProvider{zones: map[string]hostList{
+		"test.com": {
+			{hostname: "test2.test.com", ip: net.ParseIP("10.0.0.20"), ttl: 300},
+			{hostname: "old.test.com", ip: net.ParseIP("10.0.0.9"), ttl: 3600},
+			// Published while test1 had an aliases=cname annotation
+			{hostname: "mail.test.com", rtype: "CNAME", target: "test1.test.com", ttl: 3600},
+			// Not an alias in the hosts file, so left alone
+			{hostname: "ftp.test.com", rtype: "CNAME", target: "test1.test.com", ttl: 3600},
+		},
+		"dmz.test.com": {
+			// Left from a line that is no longer annotated
+			{hostname: "test6.dmz.test.com", ip: net.ParseIP("10.0.0.6"), ttl: 3600},
+		},
+	}}
+
+	records := func(hosts hostList) []string {
+		result := []string{}
+		for _, h := range hosts {
+			result = append(result, fmt.Sprintf("%v %v %v %v", h.hostname, h.recordType(), h.value(), h.ttl))
+		}
+		return result
+	}
+
+	plans, err := computePlan(p, job)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(plans))
+
+	assert.Equal(t, "test.com", plans[0].domain)
+	assert.Equal(t, []string{
+		"test1.test.com A 10.0.0.1 3600",
+		"test3.test.com A 10.0.0.3 60",
+		"test5.test.com A 10.0.0.5 3600",
+		"www.test.com CNAME test3.test.com 60",
+	}, records(plans[0].toUpdate))
+	deleted := records(plans[0].toDelete)
+	sort.Strings(deleted)
+	assert.Equal(t, []string{
+		"mail.test.com CNAME test1.test.com 3600",
+		"old.test.com A 10.0.0.9 3600",
+	}, deleted)
+
+	assert.Equal(t, "dmz.test.com", plans[1].domain)
+	assert.Equal(t, []string{"test4.dmz.test.com A 10.0.0.4 3600"}, records(plans[1].toUpdate))
+	assert.Equal(t, []string{"test6.dmz.test.com A 10.0.0.6 3600"}, records(plans[1].toDelete))
+}
+
+func TestComputePlanMultiValueSets(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("10.0.0.1 test1\n")
+	f.WriteString("10.0.0.2 test2\n")
+	f.Close()
+
+	var n CIDRNet
+	n.UnmarshalFlag("10.0.0.0/8")
+	job := syncJob{file: f.Name(), domain: "test.com", networks: []CIDRNet{n}, ttl: 3600}
+
+	p := &fakeZoneProvider{zones: map[string]hostList{
+		"test.com": {
+			{hostname: "test1.test.com", ip: net.ParseIP("10.0.0.5"), ttl: 3600},
+			{hostname: "test1.test.com", ip: net.ParseIP("10.0.0.6"), ttl: 3600},
+		},
+	}}
+
+	// Without --multi-value the existing set is neither replaced nor deleted
+	plans, err := computePlan(p, job)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(plans))
+	assert.Equal(t, hostList{
+		{hostname: "test2.test.com", ip: net.ParseIP("10.0.0.2"), aliases: []string{}, ttl: 3600},
+	}, plans[0].toUpdate)
+	assert.Equal(t, hostList{}, plans[0].toDelete)
+}
+
+func TestLoadOpts(t *testing.T) {
+	o, jobs, err := loadOpts([]string{"-d", "test.com.", "--network=10.0.0.0/8", "--ttl=60"}, flags.None)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(60), o.TTL)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "test.com", jobs[0].domain)
+
+	for _, args := range [][]string{
+		{"--network=10.0.0.0/8"},
+		{"-d", "test.com", "--network=10.0.0.0/8", "--owner-id=a b"},
+		{"-d", "test.com", "--network=10.0.0.0/8", "--adopt-records"},
+		{"-d", "test.com", "--network=10.0.0.0/8", "--config=/nonexistent.yaml"},
+		{"-m", "apply"},
+		{"-d", "test.com", "--network=10.0.0.0/8", "--syslog-facility=nonexistent"},
+		{"-d", "test.com", "--network=10.0.0.0/8", "--provider=zonefile", "--zone=dmz.test.com"},
+	} {
+		_, _, err := loadOpts(args, flags.None)
+		assert.NotNil(t, err, args)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is a family of Prometheus counters or gauges sharing a name, with
+// one value for each set of label values.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// allMetrics holds every metric, in the order they are exported.
+var allMetrics []*metric
+
+func newMetric(kind string, name string, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	allMetrics = append(allMetrics, m)
+	return m
+}
+
+var (
+	syncAttempts = newMetric("counter", "synchosts_sync_attempts_total",
+		"Number of syncs attempted.", "job")
+	syncFailures = newMetric("counter", "synchosts_sync_failures_total",
+		"Number of syncs that failed.", "job")
+	lastSuccess = newMetric("gauge", "synchosts_last_success_timestamp_seconds",
+		"Time of the last successful sync, in seconds since the epoch.", "job")
+	recordChanges = newMetric("counter", "synchosts_record_changes_total",
+		"Number of record sets added, changed and deleted.", "job", "action")
+	lastRecordChanges = newMetric("gauge", "synchosts_last_sync_record_changes",
+		"Number of record sets added, changed and deleted by the last sync.", "job", "action")
+	hostsParsed = newMetric("gauge", "synchosts_hosts_parsed",
+		"Number of hosts read from the input file the last time it was read.", "file")
+	hostsSkipped = newMetric("gauge", "synchosts_hosts_skipped",
+		"Number of lines skipped as invalid the last time the input file was read.", "file")
+	fileEvents = newMetric("counter", "synchosts_file_events_total",
+		"Number of change events seen for the input file.", "file")
+	route53Requests = newMetric("counter", "synchosts_route53_requests_total",
+		"Number of Route 53 API requests.", "operation")
+	route53Seconds = newMetric("counter", "synchosts_route53_request_seconds_total",
+		"Total time spent on Route 53 API requests, including retries.", "operation")
+	route53Errors = newMetric("counter", "synchosts_route53_errors_total",
+		"Number of Route 53 API requests that failed, by error code.", "operation", "code")
+)
+
+// key joins label values into a map key.
+func (m *metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("%v takes %d labels, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+// add adds v to the value with the given label values.
+func (m *metric) add(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] += v
+}
+
+// inc adds one to the value with the given label values.
+func (m *metric) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+// set replaces the value with the given label values.
+func (m *metric) set(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = v
+}
+
+// get returns the value with the given label values.
+func (m *metric) get(labelValues ...string) float64 {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// write writes the metric in the Prometheus text exposition format.
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %v %v\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		labels := ""
+		if len(m.labels) > 0 {
+			pairs := []string{}
+			for i, v := range strings.Split(key, "\x00") {
+				pairs = append(pairs, fmt.Sprintf(`%v="%v"`, m.labels[i], labelEscaper.Replace(v)))
+			}
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		fmt.Fprintf(w, "%v%v %v\n", m.name, labels, m.values[key])
+	}
+}
+
+// writeMetrics writes every metric in the Prometheus text exposition format.
+func writeMetrics(w io.Writer) {
+	for _, m := range allMetrics {
+		m.write(w)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+// recordSyncMetrics updates the sync metrics of job from the outcome of a
+// sync.
+func recordSyncMetrics(job syncJob, status syncStatus) {
+	name := job.String()
+	syncAttempts.inc(name)
+	if status.err != nil {
+		syncFailures.inc(name)
+	} else {
+		lastSuccess.set(float64(status.finished.UnixNano())/float64(time.Second), name)
+	}
+
+	counts := map[string]int{}
+	if status.err == nil {
+		for _, p := range status.plans {
+			for _, l := range planLines(p) {
+				counts[l.action]++
+			}
+		}
+	}
+	for _, action := range []string{"add", "change", "delete"} {
+		recordChanges.add(float64(counts[action]), name, action)
+		lastRecordChanges.set(float64(counts[action]), name, action)
+	}
+}
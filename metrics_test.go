@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resetMetrics clears every metric, so that tests don't see values left by
+// other tests, or by their own earlier runs.
+func resetMetrics() {
+	for _, m := range allMetrics {
+		m.mu.Lock()
+		m.values = map[string]float64{}
+		m.mu.Unlock()
+	}
+}
+
+func TestMetricWrite(t *testing.T) {
+	m := &metric{
+		name:   "test_total",
+		help:   "Test counter.",
+		kind:   "counter",
+		labels: []string{"job", "action"},
+		values: map[string]float64{},
+	}
+	m.inc("b.test.com", "add")
+	m.add(2, "a.test.com", "delete")
+	m.inc("a.test.com", "delete")
+	m.inc(`quote".test.com`, "add")
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{job="a.test.com",action="delete"} 3
+test_total{job="b.test.com",action="add"} 1
+test_total{job="quote\".test.com",action="add"} 1
+`, buf.String())
+
+	assert.Panics(t, func() { m.inc("a.test.com") })
+
+	gauge := &metric{name: "test", help: "Test gauge.", kind: "gauge", values: map[string]float64{}}
+	gauge.set(1.5)
+	buf.Reset()
+	gauge.write(&buf)
+	assert.Equal(t, "# HELP test Test gauge.\n# TYPE test gauge\ntest 1.5\n", buf.String())
+}
+
+func TestRecordSyncMetrics(t *testing.T) {
+	resetMetrics()
+	job := syncJob{file: "/etc/hosts", domain: "metrics.test.com"}
+	name := job.String()
+	finished := time.Unix(1564500000, 0)
+
+	status := syncStatus{
+		finished: finished,
+		plans: syncPlans{
+			{
+				domain: "metrics.test.com",
+				toUpdate: hostList{
+					{hostname: "test1.metrics.test.com", ip: net.ParseIP("1.2.3.4")},
+					{hostname: "test2.metrics.test.com", ip: net.ParseIP("1.2.3.5")},
+				},
+				toDelete: hostList{{hostname: "test3.metrics.test.com", ip: net.ParseIP("1.2.3.6")}},
+				current: hostList{
+					{hostname: "test2.metrics.test.com", ip: net.ParseIP("1.2.3.7")},
+					{hostname: "test3.metrics.test.com", ip: net.ParseIP("1.2.3.6")},
+				},
+			},
+		},
+	}
+	recordSyncMetrics(job, status)
+
+	assert.Equal(t, float64(1), syncAttempts.get(name))
+	assert.Equal(t, float64(0), syncFailures.get(name))
+	assert.Equal(t, float64(1564500000), lastSuccess.get(name))
+	assert.Equal(t, float64(1), lastRecordChanges.get(name, "add"))
+	assert.Equal(t, float64(1), lastRecordChanges.get(name, "change"))
+	assert.Equal(t, float64(1), lastRecordChanges.get(name, "delete"))
+
+	// A failed sync doesn't count its changes or move the last success time
+	recordSyncMetrics(job, syncStatus{
+		finished: finished.Add(time.Minute),
+		err:      errors.New("rate exceeded"),
+		plans:    status.plans,
+	})
+
+	assert.Equal(t, float64(2), syncAttempts.get(name))
+	assert.Equal(t, float64(1), syncFailures.get(name))
+	assert.Equal(t, float64(1564500000), lastSuccess.get(name))
+	assert.Equal(t, float64(0), lastRecordChanges.get(name, "add"))
+	assert.Equal(t, float64(1), recordChanges.get(name, "add"))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	resetMetrics()
+	fileEvents.inc("/etc/hosts.metrics")
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "# TYPE synchosts_sync_attempts_total counter\n")
+	assert.Contains(t, w.Body.String(), `synchosts_file_events_total{file="/etc/hosts.metrics"} 1`)
+}
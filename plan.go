@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// syncPlan is the set of changes needed to bring a zone in line with the
+// local hosts file, along with the records it was computed against.  current
+// holds the managed records, and zone every record that was read from the
+// zone.  ttl is the default TTL of the job the plan belongs to.
+type syncPlan struct {
+	domain   string
+	ttl      int64
+	toUpdate hostList
+	toDelete hostList
+	current  hostList
+	zone     hostList
+}
+
+func (p syncPlan) hasChanges() bool {
+	return len(p.toUpdate) > 0 || len(p.toDelete) > 0
+}
+
+// syncPlans holds a plan for each zone being synced.
+type syncPlans []syncPlan
+
+func (ps syncPlans) hasChanges() bool {
+	for _, p := range ps {
+		if p.hasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// planLine is a single row of the rendered plan.
+type planLine struct {
+	action   string
+	hostname string
+	rtype    string
+	ttl      string
+	oldValue string
+	newValue string
+}
+
+// planLines turns a plan into a sorted list of add, change and delete rows.
+// Each record set is a single row, with multiple values joined by commas.
+func planLines(p syncPlan) []planLine {
+	current, _ := groupByKey(p.current)
+
+	lines := make([]planLine, 0, len(p.toUpdate)+len(p.toDelete))
+	updates, keys := groupByKey(p.toUpdate)
+	for _, key := range keys {
+		set := updates[key]
+		line := planLine{
+			action:   "add",
+			hostname: set[0].hostname,
+			rtype:    set[0].recordType(),
+			ttl:      fmt.Sprint(set.ttl(0)),
+			oldValue: "-",
+			newValue: strings.Join(set.values(), ","),
+		}
+		if old, ok := current[key]; ok {
+			line.action = "change"
+			line.oldValue = strings.Join(old.values(), ",")
+			if old.ttl(0) != set.ttl(0) {
+				line.ttl = fmt.Sprintf("%v->%v", old.ttl(0), set.ttl(0))
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	deletes, keys := groupByKey(p.toDelete)
+	for _, key := range keys {
+		set := deletes[key]
+		lines = append(lines, planLine{
+			action:   "delete",
+			hostname: set[0].hostname,
+			rtype:    set[0].recordType(),
+			ttl:      fmt.Sprint(set.ttl(0)),
+			oldValue: strings.Join(set.values(), ","),
+			newValue: "-",
+		})
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].hostname != lines[j].hostname {
+			return lines[i].hostname < lines[j].hostname
+		}
+		return lines[i].rtype < lines[j].rtype
+	})
+
+	return lines
+}
+
+// renderPlan writes a human readable table of the pending changes in every
+// zone to w.
+func renderPlan(w io.Writer, plans syncPlans) {
+	if !plans.hasChanges() {
+		fmt.Fprintln(w, "No changes needed.  Everything in sync.")
+		return
+	}
+
+	lines := []planLine{}
+	for _, p := range plans {
+		lines = append(lines, planLines(p)...)
+	}
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tNAME\tTYPE\tTTL\tOLD\tNEW")
+	for _, l := range lines {
+		counts[l.action]++
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			l.action, l.hostname, l.rtype, l.ttl, l.oldValue, l.newValue)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nPlan: %d to add, %d to change, %d to delete.\n",
+		counts["add"], counts["change"], counts["delete"])
+}
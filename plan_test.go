@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPlan(t *testing.T) {
+	plan := syncPlan{
+		domain: "test.com",
+		toUpdate: hostList{
+			{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6"), ttl: 3600},
+			{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1"), ttl: 3600},
+			{hostname: "test4.test.com", ip: net.ParseIP("1.2.3.8"), ttl: 60},
+		},
+		toDelete: hostList{
+			{hostname: "test3.test.com", ip: net.ParseIP("1.2.3.7"), ttl: 300},
+		},
+		current: hostList{
+			{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5"), ttl: 3600},
+			{hostname: "test3.test.com", ip: net.ParseIP("1.2.3.7"), ttl: 300},
+			{hostname: "test4.test.com", ip: net.ParseIP("1.2.3.8"), ttl: 3600},
+		},
+	}
+
+	var buf bytes.Buffer
+	renderPlan(&buf, syncPlans{plan})
+	expected := "" +
+		"ACTION  NAME            TYPE  TTL       OLD      NEW\n" +
+		"add     test1.test.com  AAAA  3600      -        2001:db8::1\n" +
+		"change  test2.test.com  A     3600      1.2.3.5  1.2.3.6\n" +
+		"delete  test3.test.com  A     300       1.2.3.7  -\n" +
+		"change  test4.test.com  A     3600->60  1.2.3.8  1.2.3.8\n" +
+		"\n" +
+		"Plan: 1 to add, 2 to change, 1 to delete.\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRenderPlanMultiValue(t *testing.T) {
+	plan := syncPlan{
+		domain: "test.com",
+		toUpdate: hostList{
+			{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5"), ttl: 300},
+			{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 300},
+		},
+		current: hostList{
+			{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 300},
+		},
+	}
+
+	var buf bytes.Buffer
+	renderPlan(&buf, syncPlans{plan})
+	expected := "" +
+		"ACTION  NAME            TYPE  TTL  OLD      NEW\n" +
+		"change  test1.test.com  A     300  1.2.3.4  1.2.3.4,1.2.3.5\n" +
+		"\n" +
+		"Plan: 0 to add, 1 to change, 0 to delete.\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRenderPlanNoChanges(t *testing.T) {
+	var buf bytes.Buffer
+	renderPlan(&buf, syncPlans{{domain: "test.com"}})
+	assert.Equal(t, "No changes needed.  Everything in sync.\n", buf.String())
+}
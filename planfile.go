@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// planFileVersion is bumped whenever the format changes, so that plans saved
+// by an older version are refused instead of being misread.  Version 2 holds
+// a list of zones, version 3 a snapshot of each of them and version 4 the TTL
+// of each.
+const planFileVersion = 4
+
+// planFile is the on-disk form of a plan.  Along with the changes to make in
+// each zone, it records every record in the zone at the time, so that it can
+// be refused if the zone has changed before it is applied.
+type planFile struct {
+	Version  int        `json:"version"`
+	Created  time.Time  `json:"created"`
+	Provider string     `json:"provider"`
+	OwnerID  string     `json:"owner_id,omitempty"`
+	Zones    []planZone `json:"zones"`
+}
+
+// planZone holds the planned changes for a single zone.  Current holds the
+// managed records as they were when the plan was computed, and Zone every
+// record in the zone.  TTL is the default for records without a TTL of their
+// own, which comes from the job the zone was synced by.
+type planZone struct {
+	Domain  string       `json:"domain"`
+	TTL     int64        `json:"ttl"`
+	Update  []planRecord `json:"update"`
+	Delete  []planRecord `json:"delete"`
+	Current []planRecord `json:"current"`
+	Zone    []planRecord `json:"zone"`
+}
+
+type planRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+func newPlanRecord(h hostEntry) planRecord {
+	return planRecord{
+		Name:  h.hostname,
+		Type:  h.recordType(),
+		Value: h.value(),
+		TTL:   h.ttl,
+	}
+}
+
+func (r planRecord) hostEntry() (hostEntry, error) {
+	if r.Type != "A" && r.Type != "AAAA" {
+		return hostEntry{hostname: r.Name, rtype: r.Type, target: r.Value, ttl: r.TTL}, nil
+	}
+
+	ip := net.ParseIP(r.Value)
+	if ip == nil {
+		return hostEntry{}, fmt.Errorf("%s is not a valid IP", r.Value)
+	}
+
+	h := hostEntry{hostname: r.Name, ip: ip, ttl: r.TTL}
+	if h.recordType() != r.Type {
+		return hostEntry{}, fmt.Errorf("%s is not valid for a %s record", r.Value, r.Type)
+	}
+	return h, nil
+}
+
+// newPlanRecords converts a host list into plan records, sorted so that the
+// same set of hosts always produces the same output.
+func newPlanRecords(hosts hostList) []planRecord {
+	sorted := make(hostList, len(hosts))
+	copy(sorted, hosts)
+	sort.Sort(sorted)
+
+	records := make([]planRecord, 0, len(sorted))
+	for _, h := range sorted {
+		records = append(records, newPlanRecord(h))
+	}
+	return records
+}
+
+func newPlanZone(p syncPlan) planZone {
+	return planZone{
+		Domain:  p.domain,
+		TTL:     p.ttl,
+		Update:  newPlanRecords(p.toUpdate),
+		Delete:  newPlanRecords(p.toDelete),
+		Current: newPlanRecords(p.current),
+		Zone:    newPlanRecords(p.zone),
+	}
+}
+
+func newPlanFile(plans syncPlans) planFile {
+	pf := planFile{
+		Version:  planFileVersion,
+		Created:  time.Now().UTC(),
+		Provider: opts.Provider,
+		OwnerID:  opts.OwnerID,
+	}
+
+	for _, p := range plans {
+		pf.Zones = append(pf.Zones, newPlanZone(p))
+	}
+
+	return pf
+}
+
+func writePlanFile(filename string, pf planFile) error {
+	data, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode plan")
+	}
+
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func readPlanFile(filename string) (planFile, error) {
+	var pf planFile
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return pf, err
+	}
+
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return pf, errors.Wrapf(err, "Cannot parse plan file %v", filename)
+	}
+
+	if pf.Version != planFileVersion {
+		return pf, fmt.Errorf("unsupported plan file version %v", pf.Version)
+	}
+
+	return pf, nil
+}
+
+// matches reports whether the records in a zone are the same as when the
+// plan was computed.  Every record is compared, not just the managed ones,
+// since a new record can change what the plan should have done with it.
+func (pz planZone) matches(zoneHosts hostList) bool {
+	current := newPlanRecords(zoneHosts)
+	if len(current) == 0 && len(pz.Zone) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(current, pz.Zone)
+}
+
+// changes converts the plan records back into the hosts to update and delete.
+// Records to delete are looked up in zoneHosts, since providers need the
+// record as it was read from the zone to delete it.
+func (pz planZone) changes(zoneHosts hostList) (hostList, hostList, error) {
+	toUpdate := make(hostList, 0, len(pz.Update))
+	for _, r := range pz.Update {
+		h, err := r.hostEntry()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Invalid record for %v in plan", r.Name)
+		}
+		toUpdate = append(toUpdate, h)
+	}
+
+	// Multi-value record sets have several hosts with the same key, so
+	// include the value to find the exact record being deleted.
+	byKey := make(map[string]hostEntry, len(zoneHosts))
+	for _, h := range zoneHosts {
+		byKey[h.key()+"/"+h.value()] = h
+	}
+
+	toDelete := make(hostList, 0, len(pz.Delete))
+	for _, r := range pz.Delete {
+		h, err := r.hostEntry()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Invalid record for %v in plan", r.Name)
+		}
+		rh, ok := byKey[h.key()+"/"+h.value()]
+		if !ok {
+			return nil, nil, fmt.Errorf("record %v (%v) to delete no longer exists", r.Name, r.Type)
+		}
+		toDelete = append(toDelete, rh)
+	}
+
+	return toUpdate, toDelete, nil
+}
+
+// runApply applies a previously saved plan, as long as the zones still match
+// the records the plan was computed against.  The return value is the exit
+// code.
+func runApply() int {
+	pf, err := readPlanFile(opts.PlanFile)
+	if err != nil {
+		log.Error(errors.Wrap(err, "Cannot read plan"))
+		return 1
+	}
+
+	p, err := newProvider(pf.Provider)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	// Ownership records are only read back with an owner ID, so it's needed
+	// for the zones to match
+	opts.OwnerID = pf.OwnerID
+
+	// Check every zone before changing any of them
+	toUpdate := make([]hostList, len(pf.Zones))
+	toDelete := make([]hostList, len(pf.Zones))
+	for i, pz := range pf.Zones {
+		zoneHosts, err := p.getHosts(pz.Domain)
+		if err != nil {
+			log.Error(errors.Wrap(err, "error when retrieving zones"))
+			return 1
+		}
+
+		if !pz.matches(zoneHosts) {
+			log.Errorf("Records in %v have changed since the plan was created, refusing to apply",
+				pz.Domain)
+			return 1
+		}
+
+		toUpdate[i], toDelete[i], err = pz.changes(zoneHosts)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+	}
+
+	changed := false
+	for i, pz := range pf.Zones {
+		if len(toUpdate[i]) == 0 && len(toDelete[i]) == 0 {
+			continue
+		}
+		changed = true
+
+		if err := applyChanges(p, pz.Domain, pz.TTL, toUpdate[i], toDelete[i]); err != nil {
+			log.Error(errors.Wrapf(err, "Could not sync records in %v", pz.Domain))
+			return 1
+		}
+	}
+
+	if !changed {
+		log.Info("Plan contains no changes.")
+	}
+
+	return 0
+}
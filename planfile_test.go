@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(ownerID string) { opts.OwnerID = ownerID }(opts.OwnerID)
+	opts.OwnerID = "test"
+
+	pf := newPlanFile(syncPlans{{
+		domain: "test.com",
+		ttl:    300,
+		toUpdate: hostList{
+			{hostname: "test2.test.com", ip: net.ParseIP("2001:db8::1")},
+			{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+			{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+		},
+	}})
+	filename := filepath.Join(dir, "plan.json")
+	assert.Nil(t, writePlanFile(filename, pf))
+
+	read, err := readPlanFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(read.Zones))
+	assert.Equal(t, "test.com", read.Zones[0].Domain)
+	assert.Equal(t, int64(300), read.Zones[0].TTL)
+	assert.Equal(t, "test", read.OwnerID)
+	assert.Equal(t, []planRecord{
+		{Name: "test1.test.com", Type: "A", Value: "1.2.3.4"},
+		{Name: "test2.test.com", Type: "AAAA", Value: "2001:db8::1"},
+		{Name: "www.test.com", Type: "CNAME", Value: "test1.test.com"},
+	}, read.Zones[0].Update)
+
+	cname, err := read.Zones[0].Update[2].hostEntry()
+	assert.Nil(t, err)
+	assert.Equal(t, hostEntry{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"}, cname)
+
+	// Plans saved in an older format are refused
+	old := filepath.Join(dir, "old.json")
+	assert.Nil(t, ioutil.WriteFile(old, []byte(`{"version": 3, "ttl": 3600, "zones": []}`), 0644))
+	_, err = readPlanFile(old)
+	assert.EqualError(t, err, "unsupported plan file version 3")
+}
+
+func TestPlanZoneMatches(t *testing.T) {
+	zone := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
+		{hostname: "other.test.com", ip: net.ParseIP("10.0.0.1")},
+	}
+	pz := newPlanZone(syncPlan{
+		domain:   "test.com",
+		toUpdate: hostList{{hostname: "test3.test.com", ip: net.ParseIP("1.2.3.6")}},
+		current:  zone[:2],
+		zone:     zone,
+	})
+
+	assert.True(t, pz.matches(hostList{zone[2], zone[1], zone[0]}))
+	assert.False(t, pz.matches(zone[:2]))
+	assert.False(t, pz.matches(hostList{
+		zone[0],
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6")},
+		zone[2],
+	}))
+	// A record appearing for a name the plan will update is a conflict
+	assert.False(t, pz.matches(append(hostList{
+		{hostname: "test3.test.com", ip: net.ParseIP("1.2.3.7")},
+	}, zone...)))
+	// So is any other change to the zone, even to records it doesn't manage
+	assert.False(t, pz.matches(append(hostList{
+		{hostname: "new.test.com", ip: net.ParseIP("1.2.3.8")},
+	}, zone...)))
+	assert.False(t, pz.matches(hostList{
+		zone[0], zone[1],
+		{hostname: "other.test.com", ip: net.ParseIP("10.0.0.1"), ttl: 60},
+	}))
+	assert.True(t, planZone{}.matches(hostList{}))
+}
+
+func TestPlanZoneChanges(t *testing.T) {
+	current := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+	}
+	pz := planZone{
+		Update: []planRecord{{Name: "test2.test.com", Type: "A", Value: "1.2.3.5"}},
+		Delete: []planRecord{{Name: "test1.test.com", Type: "A", Value: "1.2.3.4"}},
+	}
+
+	toUpdate, toDelete, err := pz.changes(current)
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")}}, toUpdate)
+	assert.Equal(t, current, toDelete)
+
+	_, _, err = pz.changes(hostList{})
+	assert.NotNil(t, err)
+
+	pz.Update[0].Type = "AAAA"
+	_, _, err = pz.changes(current)
+	assert.NotNil(t, err)
+}
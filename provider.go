@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// dnsProvider is a DNS backend that the local hosts can be synchronized to.
+// The providers only deal with reading and writing records, all of the
+// filtering and comparison is done before changes are handed to them.
+type dnsProvider interface {
+	// getHosts returns the address records currently in the zone for domain.
+	getHosts(domain string) (hostList, error)
+	// sync submits changes to the zone for domain.  Hosts to delete are
+	// always ones previously returned by getHosts.
+	sync(domain string, ttl int64, toUpdate hostList, toDelete hostList) error
+	// wait blocks until all changes submitted by sync have propagated.
+	wait() error
+}
+
+// changeTracker is implemented by providers that apply changes
+// asynchronously, to report the changes that haven't been waited on yet.
+type changeTracker interface {
+	pendingChanges() []string
+}
+
+// newProvider returns the DNS provider with the given name.
+func newProvider(name string) (dnsProvider, error) {
+	switch name {
+	case "route53":
+		return newRoute53(), nil
+	case "rfc2136":
+		return newRFC2136()
+	case "zonefile":
+		return newZoneFile()
+	}
+
+	return nil, fmt.Errorf("unknown provider '%v'", name)
+}
+
+// applyChanges submits changes to the provider and, unless --no-wait was
+// given, waits for them to propagate.
+func applyChanges(p dnsProvider, domain string, ttl int64, toUpdate hostList, toDelete hostList) error {
+	log.Infof("Adding/updating %v records, deleting %v out of date records",
+		len(toUpdate), len(toDelete))
+
+	if err := p.sync(domain, ttl, toUpdate, toDelete); err != nil {
+		return err
+	}
+
+	if opts.NoWait {
+		log.Info("Sync queued for update")
+		return nil
+	}
+
+	log.Info("Waiting for update to complete")
+	if err := p.wait(); err != nil {
+		return errors.Wrap(err, "Update failed during wait")
+	}
+	log.Info("Sync completed successfully")
+
+	return nil
+}
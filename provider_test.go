@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider is an in-memory dnsProvider for testing.
+type fakeProvider struct {
+	hosts    hostList
+	updated  hostList
+	deleted  hostList
+	syncs    int
+	waits    int
+	syncErr  error
+	hostsErr error
+}
+
+func (f *fakeProvider) getHosts(domain string) (hostList, error) {
+	return f.hosts, f.hostsErr
+}
+
+func (f *fakeProvider) sync(domain string, ttl int64, toUpdate hostList, toDelete hostList) error {
+	f.syncs++
+	f.updated = append(f.updated, toUpdate...)
+	f.deleted = append(f.deleted, toDelete...)
+	return f.syncErr
+}
+
+func (f *fakeProvider) wait() error {
+	f.waits++
+	return nil
+}
+
+func TestApplyChangesWaits(t *testing.T) {
+	defer func(noWait bool) { opts.NoWait = noWait }(opts.NoWait)
+
+	p := &fakeProvider{}
+	toUpdate := hostList{{hostname: "test1.test.com"}}
+	opts.NoWait = false
+	assert.Nil(t, applyChanges(p, "test.com", 300, toUpdate, hostList{}))
+	assert.Equal(t, 1, p.syncs)
+	assert.Equal(t, 1, p.waits)
+	assert.Equal(t, toUpdate, p.updated)
+
+	opts.NoWait = true
+	assert.Nil(t, applyChanges(p, "test.com", 300, toUpdate, hostList{}))
+	assert.Equal(t, 2, p.syncs)
+	assert.Equal(t, 1, p.waits)
+}
+
+func TestNewProvider(t *testing.T) {
+	_, err := newProvider("bogus")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// zoneFinder is implemented by providers that can work out which of their
+// zones a name belongs to.  This is needed to find reverse zones.
+type zoneFinder interface {
+	// findZone returns the most specific zone that contains name.
+	findZone(name string) (string, error)
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for an address.
+func reverseName(ip net.IP) string {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return ""
+	}
+	return canonifyHostname(name)
+}
+
+// reverseNameToIP converts an in-addr.arpa or ip6.arpa name back into the
+// address it represents.  Nil is returned for names that don't represent a
+// single address.
+func reverseNameToIP(name string) net.IP {
+	name = canonifyHostname(name)
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return nil
+		}
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+		return net.ParseIP(strings.Join(labels, "."))
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(nibbles) != 32 {
+			return nil
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, n := range nibbles {
+			v, err := strconv.ParseUint(n, 16, 8)
+			if err != nil || len(n) != 1 {
+				return nil
+			}
+			// Nibbles are least significant first
+			pos := 31 - i
+			ip[pos/2] |= byte(v) << uint(4*(1-pos%2))
+		}
+		return ip
+	}
+
+	return nil
+}
+
+// ptrRecords returns a PTR record for each address record in hosts.
+func ptrRecords(hosts hostList) hostList {
+	ptrs := hostList{}
+	for _, h := range hosts {
+		if h.ip == nil {
+			continue
+		}
+		ptrs = append(ptrs, hostEntry{
+			hostname: reverseName(h.ip),
+			rtype:    "PTR",
+			target:   h.hostname,
+			ttl:      h.ttl,
+		})
+	}
+	return ptrs
+}
+
+// filterPTRsByNetwork returns the PTR records in hosts for addresses in one of
+// the given networks.
+func filterPTRsByNetwork(hosts hostList, networks []CIDRNet) hostList {
+	output := hostList{}
+	for _, h := range hosts {
+		if h.recordType() != "PTR" {
+			continue
+		}
+		ip := reverseNameToIP(h.hostname)
+		if ip == nil {
+			continue
+		}
+		for _, n := range networks {
+			if n.Contains(ip) {
+				output = append(output, h)
+				break
+			}
+		}
+	}
+	return output
+}
+
+// removeExcludedPTRs drops PTR records that point at an excluded host.
+func removeExcludedPTRs(hosts hostList, excludeList []string) hostList {
+	excluded := make(map[string]bool, len(excludeList))
+	for _, eh := range excludeList {
+		excluded[eh] = true
+	}
+
+	hl := make(hostList, 0, len(hosts))
+	for _, h := range hosts {
+		if !excluded[h.target] {
+			hl = append(hl, h)
+		}
+	}
+	return hl
+}
+
+// computePTRPlans works out the changes needed to keep PTR records in step
+// with the forward address records.  hosts are the forward records that
+// should exist, and current are the ones that exist now, so that reverse
+// zones for hosts that are being removed are also cleaned up.
+func computePTRPlans(p dnsProvider, job syncJob, hosts hostList, current hostList) (syncPlans, error) {
+	finder, ok := p.(zoneFinder)
+	if !ok {
+		return nil, fmt.Errorf("provider %v does not support PTR records", opts.Provider)
+	}
+
+	// Reverse names in the same parent almost always share a zone, so cache
+	// lookups by parent to avoid looking up every address.
+	zoneByParent := map[string]string{}
+	zoneFor := func(name string) (string, error) {
+		parent := name[strings.Index(name, ".")+1:]
+		if zone, ok := zoneByParent[parent]; ok {
+			return zone, nil
+		}
+		zone, err := finder.findZone(name)
+		if err != nil {
+			return "", err
+		}
+		zoneByParent[parent] = zone
+		return zone, nil
+	}
+
+	desired := map[string]hostList{}
+	// Addresses without a reverse zone are skipped, rather than holding up
+	// the rest of the sync
+	noZone := map[string]bool{}
+	for _, h := range append(ptrRecords(current), ptrRecords(hosts)...) {
+		if noZone[h.hostname] {
+			continue
+		}
+		zone, err := zoneFor(h.hostname)
+		if errors.Cause(err) == errZoneNotFound {
+			log.Warnf("No reverse zone for %v, not managing its PTR record", h.hostname)
+			noZone[h.hostname] = true
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot find reverse zone for %v", h.hostname)
+		}
+		if _, ok := desired[zone]; !ok {
+			desired[zone] = hostList{}
+		}
+	}
+	for _, h := range removeDupes(ptrRecords(hosts)) {
+		if noZone[h.hostname] {
+			continue
+		}
+		// Every zone has already been looked up above
+		zone, _ := zoneFor(h.hostname)
+		desired[zone] = append(desired[zone], h)
+	}
+
+	zones := make([]string, 0, len(desired))
+	for zone := range desired {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	plans := syncPlans{}
+	for _, zone := range zones {
+		allZoneHosts, err := p.getHosts(zone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot get records for %v", zone)
+		}
+		zoneHosts := filterPTRsByNetwork(allZoneHosts, job.networks)
+		zoneHosts = removeExcludedPTRs(zoneHosts, job.excludes)
+
+		wanted := desired[zone]
+		if opts.OwnerID != "" {
+			wanted, zoneHosts = applyOwnership(wanted, zoneHosts, allZoneHosts, opts.OwnerID, opts.AdoptRecords)
+		}
+
+		toUpdate, toDelete := compareHosts(wanted, zoneHosts)
+		plans = append(plans, syncPlan{
+			domain:   zone,
+			ttl:      job.ttl,
+			toUpdate: toUpdate,
+			toDelete: toDelete,
+			current:  zoneHosts,
+			zone:     allZoneHosts,
+		})
+	}
+
+	return plans, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseName(t *testing.T) {
+	cases := []struct {
+		ip   string
+		name string
+	}{
+		{"10.20.1.2", "2.1.20.10.in-addr.arpa"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			assert.Equal(t, c.name, reverseName(net.ParseIP(c.ip)))
+			assert.Equal(t, net.ParseIP(c.ip), reverseNameToIP(c.name))
+		})
+	}
+
+	assert.Nil(t, reverseNameToIP("20.10.in-addr.arpa"))
+	assert.Nil(t, reverseNameToIP("test.com"))
+	assert.Nil(t, reverseNameToIP("x.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"))
+}
+
+// fakeZoneProvider is a fakeProvider with several zones that also implements
+// zoneFinder.
+type fakeZoneProvider struct {
+	fakeProvider
+	zones map[string]hostList
+}
+
+func (f *fakeZoneProvider) getHosts(domain string) (hostList, error) {
+	return f.zones[domain], nil
+}
+
+func (f *fakeZoneProvider) findZone(name string) (string, error) {
+	for domain := name; domain != ""; {
+		if _, ok := f.zones[domain]; ok {
+			return domain, nil
+		}
+		i := 0
+		for i < len(domain) && domain[i] != '.' {
+			i++
+		}
+		if i == len(domain) {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return "", errors.Wrapf(errZoneNotFound, "no zone for %v", name)
+}
+
+func TestComputePTRPlans(t *testing.T) {
+	var n CIDRNet
+	n.UnmarshalFlag("10.20.0.0/16")
+	job := syncJob{networks: []CIDRNet{n}}
+
+	p := &fakeZoneProvider{zones: map[string]hostList{
+		"20.10.in-addr.arpa": {
+			{hostname: "4.1.20.10.in-addr.arpa", rtype: "PTR", target: "old.test.com"},
+			{hostname: "5.1.20.10.in-addr.arpa", rtype: "PTR", target: "test2.test.com"},
+		},
+		"2.20.10.in-addr.arpa": {
+			{hostname: "9.2.20.10.in-addr.arpa", rtype: "PTR", target: "gone.test.com"},
+		},
+	}}
+
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("10.20.1.4")},
+		{hostname: "test2.test.com", ip: net.ParseIP("10.20.1.5")},
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+		// There's no reverse zone for this one, so it is skipped
+		{hostname: "test3.test.com", ip: net.ParseIP("10.30.1.6")},
+	}
+	current := hostList{
+		{hostname: "gone.test.com", ip: net.ParseIP("10.20.2.9")},
+	}
+
+	plans, err := computePTRPlans(p, job, hosts, current)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(plans))
+
+	assert.Equal(t, "2.20.10.in-addr.arpa", plans[0].domain)
+	assert.Equal(t, hostList{}, plans[0].toUpdate)
+	assert.Equal(t, p.zones["2.20.10.in-addr.arpa"], plans[0].toDelete)
+
+	assert.Equal(t, "20.10.in-addr.arpa", plans[1].domain)
+	assert.Equal(t, hostList{
+		{hostname: "4.1.20.10.in-addr.arpa", rtype: "PTR", target: "test1.test.com"},
+	}, plans[1].toUpdate)
+	assert.Equal(t, hostList{}, plans[1].toDelete)
+
+	_, err = computePTRPlans(&fakeProvider{}, job, hosts, current)
+	assert.NotNil(t, err)
+}
+
+func TestComputePTRPlansOwnership(t *testing.T) {
+	defer func(ownerID string) { opts.OwnerID = ownerID }(opts.OwnerID)
+	opts.OwnerID = "lan"
+
+	var n CIDRNet
+	n.UnmarshalFlag("10.20.0.0/16")
+	job := syncJob{networks: []CIDRNet{n}}
+
+	p := &fakeZoneProvider{zones: map[string]hostList{
+		"20.10.in-addr.arpa": {
+			{hostname: "4.1.20.10.in-addr.arpa", rtype: "PTR", target: "old.test.com"},
+			{hostname: "_sync-hosts.ptr.4.1.20.10.in-addr.arpa", rtype: "TXT", target: registryValue("lan")},
+			// Owned by someone else, so neither updated nor deleted
+			{hostname: "5.1.20.10.in-addr.arpa", rtype: "PTR", target: "other.test.com"},
+			{hostname: "_sync-hosts.ptr.5.1.20.10.in-addr.arpa", rtype: "TXT", target: registryValue("dmz")},
+			{hostname: "6.1.20.10.in-addr.arpa", rtype: "PTR", target: "other2.test.com"},
+			{hostname: "_sync-hosts.ptr.6.1.20.10.in-addr.arpa", rtype: "TXT", target: registryValue("dmz")},
+		},
+	}}
+
+	hosts := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("10.20.1.4")},
+		{hostname: "test2.test.com", ip: net.ParseIP("10.20.1.5")},
+	}
+
+	plans, err := computePTRPlans(p, job, hosts, hostList{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(plans))
+	assert.Equal(t, hostList{
+		{hostname: "4.1.20.10.in-addr.arpa", rtype: "PTR", target: "test1.test.com"},
+	}, plans[0].toUpdate)
+	assert.Equal(t, hostList{}, plans[0].toDelete)
+}
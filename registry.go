@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ownership of records is tracked with a companion TXT record for each
+// managed record, in the style of external-dns.  The TXT record for the A
+// record of host.example.com is named _sync-hosts.a.host.example.com and
+// holds the ID of the instance that owns it.
+const (
+	registryPrefix   = "_sync-hosts."
+	registryHeritage = "sync-hosts-to-route53"
+)
+
+// registryName returns the name of the TXT record tracking ownership of h.
+func registryName(h hostEntry) string {
+	return registryPrefix + strings.ToLower(h.recordType()) + "." + h.hostname
+}
+
+// registryKey converts a registry TXT record name back into the key of the
+// record it tracks.  False is returned if name isn't a registry record.
+func registryKey(name string) (string, bool) {
+	if !strings.HasPrefix(name, registryPrefix) {
+		return "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, registryPrefix), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+
+	return parts[1] + "/" + strings.ToUpper(parts[0]), true
+}
+
+// registryValue returns the TXT record value marking a record as owned by
+// ownerID.
+func registryValue(ownerID string) string {
+	return fmt.Sprintf("\"heritage=%s,owner=%s\"", registryHeritage, ownerID)
+}
+
+// registryOwner parses a registry TXT record value, returning the owner ID.
+// False is returned if the value wasn't written by this program.
+func registryOwner(value string) (string, bool) {
+	value = strings.Trim(value, "\"")
+	heritage, owner := "", ""
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "heritage":
+			heritage = kv[1]
+		case "owner":
+			owner = kv[1]
+		}
+	}
+
+	return owner, heritage == registryHeritage && owner != ""
+}
+
+// registryRecord returns the TXT record marking h as owned by ownerID.
+func registryRecord(h hostEntry, ownerID string) hostEntry {
+	return hostEntry{
+		hostname: registryName(h),
+		rtype:    "TXT",
+		target:   registryValue(ownerID),
+		ttl:      h.ttl,
+	}
+}
+
+// applyOwnership limits a sync to the records owned by ownerID.  hosts are
+// the records that should exist, zoneHosts the managed records that exist
+// now, and allZoneHosts everything in the zone, which is where the registry
+// records are found.
+//
+// Records that exist but aren't owned are removed from both lists, so they
+// are never changed or deleted.  If adopt is true, records that have no owner
+// at all are treated as owned, so that existing records can be migrated.
+// Registry records for everything that should exist are added to hosts, and
+// the existing registry records are added to zoneHosts, so that comparing
+// the two also keeps the registry up to date.
+func applyOwnership(hosts hostList, zoneHosts hostList, allZoneHosts hostList, ownerID string, adopt bool) (hostList, hostList) {
+	owners := map[string]string{}
+	registry := map[string]hostEntry{}
+	for _, h := range allZoneHosts {
+		if h.recordType() != "TXT" {
+			continue
+		}
+		key, ok := registryKey(h.hostname)
+		if !ok {
+			continue
+		}
+		if owner, ok := registryOwner(h.target); ok {
+			owners[key] = owner
+			registry[key] = h
+		}
+	}
+
+	owned := func(h hostEntry) bool {
+		owner, ok := owners[h.key()]
+		if !ok {
+			return adopt
+		}
+		return owner == ownerID
+	}
+
+	managedZone := hostList{}
+	foreign := map[string]bool{}
+	for _, h := range zoneHosts {
+		if owned(h) {
+			managedZone = append(managedZone, h)
+		} else {
+			foreign[h.key()] = true
+		}
+	}
+
+	managedHosts := hostList{}
+	for _, h := range hosts {
+		if foreign[h.key()] {
+			log.Warnf("%v (%v) is not owned by %v, skipping", h.hostname, h.recordType(), ownerID)
+			continue
+		}
+		managedHosts = append(managedHosts, h)
+	}
+
+	// Only consider registry records for records this sync manages, so
+	// that instances sharing an owner ID with different networks don't
+	// delete each other's registry records.
+	keys := map[string]bool{}
+	for _, h := range append(append(hostList{}, managedHosts...), managedZone...) {
+		keys[h.key()] = true
+	}
+
+	// Multi-value record sets only need a single registry record
+	resultHosts := append(hostList{}, managedHosts...)
+	registered := map[string]bool{}
+	for _, h := range managedHosts {
+		if !registered[h.key()] {
+			registered[h.key()] = true
+			resultHosts = append(resultHosts, registryRecord(h, ownerID))
+		}
+	}
+
+	resultZone := append(hostList{}, managedZone...)
+	for key, rh := range registry {
+		if keys[key] && owners[key] == ownerID {
+			resultZone = append(resultZone, rh)
+		}
+	}
+
+	return resultHosts, resultZone
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryNames(t *testing.T) {
+	h := hostEntry{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")}
+	assert.Equal(t, "_sync-hosts.aaaa.test1.test.com", registryName(h))
+
+	key, ok := registryKey(registryName(h))
+	assert.True(t, ok)
+	assert.Equal(t, h.key(), key)
+
+	_, ok = registryKey("test1.test.com")
+	assert.False(t, ok)
+	_, ok = registryKey("_sync-hosts.a")
+	assert.False(t, ok)
+}
+
+func TestRegistryOwner(t *testing.T) {
+	owner, ok := registryOwner(registryValue("router1"))
+	assert.True(t, ok)
+	assert.Equal(t, "router1", owner)
+
+	_, ok = registryOwner("\"heritage=external-dns,external-dns/owner=default\"")
+	assert.False(t, ok)
+	_, ok = registryOwner("\"v=spf1 -all\"")
+	assert.False(t, ok)
+}
+
+func TestApplyOwnership(t *testing.T) {
+	mine := hostEntry{hostname: "mine.test.com", ip: net.ParseIP("1.2.3.4")}
+	theirs := hostEntry{hostname: "theirs.test.com", ip: net.ParseIP("1.2.3.5")}
+	manual := hostEntry{hostname: "manual.test.com", ip: net.ParseIP("1.2.3.6")}
+	stale := hostEntry{hostname: "stale.test.com", ip: net.ParseIP("1.2.3.7")}
+	zoneHosts := hostList{mine, theirs, manual, stale}
+	registry := hostList{
+		registryRecord(mine, "router1"),
+		registryRecord(theirs, "router2"),
+		registryRecord(stale, "router1"),
+		// Unrelated TXT records are ignored
+		{hostname: "test.com", rtype: "TXT", target: "\"v=spf1 -all\""},
+	}
+	allZoneHosts := append(append(hostList{}, zoneHosts...), registry...)
+
+	newHost := hostEntry{hostname: "new.test.com", ip: net.ParseIP("1.2.3.8")}
+	hosts := hostList{
+		{hostname: "mine.test.com", ip: net.ParseIP("1.2.3.9")},
+		{hostname: "theirs.test.com", ip: net.ParseIP("1.2.3.9")},
+		{hostname: "manual.test.com", ip: net.ParseIP("1.2.3.9")},
+		newHost,
+	}
+
+	t.Run("owned only", func(t *testing.T) {
+		h, z := applyOwnership(hosts, zoneHosts, allZoneHosts, "router1", false)
+		toUpdate, toDelete := compareHosts(h, z)
+		sort.Sort(toUpdate)
+		sort.Sort(toDelete)
+
+		assert.Equal(t, hostList{
+			registryRecord(newHost, "router1"),
+			hosts[0],
+			newHost,
+		}, toUpdate)
+		assert.Equal(t, hostList{registry[2], stale}, toDelete)
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		h, z := applyOwnership(hosts, zoneHosts, allZoneHosts, "router1", true)
+		toUpdate, toDelete := compareHosts(h, z)
+		sort.Sort(toUpdate)
+		sort.Sort(toDelete)
+
+		assert.Equal(t, hostList{
+			registryRecord(manual, "router1"),
+			registryRecord(newHost, "router1"),
+			hosts[2],
+			hosts[0],
+			newHost,
+		}, toUpdate)
+		assert.Equal(t, hostList{registry[2], stale}, toDelete)
+	})
+}
+
+func TestApplyOwnershipMultiValue(t *testing.T) {
+	hosts := hostList{
+		{hostname: "rr.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "rr.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+
+	h, z := applyOwnership(hosts, hostList{}, hostList{}, "router1", false)
+	toUpdate, toDelete := compareHosts(h, z)
+	sort.Sort(toUpdate)
+
+	assert.Equal(t, hostList{
+		registryRecord(hosts[0], "router1"),
+		hosts[0],
+		hosts[1],
+	}, toUpdate)
+	assert.Equal(t, hostList{}, toDelete)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Number of record sets to include in a single UPDATE message, to keep
+// messages a reasonable size on large syncs.
+const rfc2136BatchSize = 100
+
+// rfc2136Client syncs records to an authoritative DNS server using RFC 2136
+// dynamic updates, optionally signed with TSIG.  The current state of the zone
+// is read with a zone transfer.
+type rfc2136Client struct {
+	server    string
+	keyName   string
+	secret    string
+	algorithm string
+	timeout   time.Duration
+}
+
+func newRFC2136() (*rfc2136Client, error) {
+	if opts.RFC2136Server == "" {
+		return nil, fmt.Errorf("DNS server must be specified for rfc2136 provider (--rfc2136-server)")
+	}
+
+	server := opts.RFC2136Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	c := &rfc2136Client{
+		server:  server,
+		timeout: 30 * time.Second,
+	}
+
+	if opts.RFC2136TSIGKey != "" {
+		if opts.RFC2136TSIGSecret == "" {
+			return nil, fmt.Errorf("TSIG secret must be given with TSIG key (--rfc2136-tsig-secret)")
+		}
+		c.keyName = dns.Fqdn(strings.ToLower(opts.RFC2136TSIGKey))
+		c.secret = opts.RFC2136TSIGSecret
+		c.algorithm = dns.Fqdn(opts.RFC2136TSIGAlgorithm)
+	}
+
+	return c, nil
+}
+
+func (c *rfc2136Client) tsigSecret() map[string]string {
+	if c.keyName == "" {
+		return nil
+	}
+	return map[string]string{c.keyName: c.secret}
+}
+
+// sign adds a TSIG record to the message if a key has been configured.  This
+// must be the last change made to the message.
+func (c *rfc2136Client) sign(m *dns.Msg) {
+	if c.keyName != "" {
+		m.SetTsig(c.keyName, c.algorithm, 300, time.Now().Unix())
+	}
+}
+
+func (c *rfc2136Client) getHosts(domain string) (hostList, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(domain))
+	c.sign(m)
+
+	t := &dns.Transfer{
+		DialTimeout:  c.timeout,
+		ReadTimeout:  c.timeout,
+		WriteTimeout: c.timeout,
+		TsigSecret:   c.tsigSecret(),
+	}
+	env, err := t.In(m, c.server)
+	if err != nil {
+		return hostList{}, errors.Wrapf(err, "Cannot transfer zone %v", domain)
+	}
+
+	rrs := []dns.RR{}
+	for e := range env {
+		if e.Error != nil {
+			return hostList{}, errors.Wrapf(e.Error, "Cannot transfer zone %v", domain)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+
+	return convertRRsToHosts(rrs), nil
+}
+
+func (c *rfc2136Client) sync(domain string, ttl int64, toUpdate hostList, toDelete hostList) error {
+	client := &dns.Client{
+		Net:        "tcp",
+		Timeout:    c.timeout,
+		TsigSecret: c.tsigSecret(),
+	}
+
+	// Build the updates for each record set, so that a set is never split
+	// across messages.
+	updates := [][]func(m *dns.Msg){}
+	groups, keys := groupByKey(toUpdate)
+	for _, key := range keys {
+		// Every record in a set has to have the same TTL
+		setTTL := groups[key].ttl(ttl)
+		rrs := make([]dns.RR, 0, len(groups[key]))
+		for _, h := range groups[key] {
+			h.ttl = setTTL
+			rrs = append(rrs, hostToRR(h, ttl))
+		}
+		// Replace whatever is there now with the new set
+		updates = append(updates, []func(m *dns.Msg){
+			func(m *dns.Msg) { m.RemoveRRset(rrs[:1]) },
+			func(m *dns.Msg) { m.Insert(rrs) },
+		})
+	}
+	for _, h := range toDelete {
+		rr := hostToRR(h, ttl)
+		updates = append(updates, []func(m *dns.Msg){
+			func(m *dns.Msg) { m.Remove([]dns.RR{rr}) },
+		})
+	}
+
+	for start := 0; start < len(updates); start += rfc2136BatchSize {
+		m := new(dns.Msg)
+		m.SetUpdate(dns.Fqdn(domain))
+
+		for i := start; i < start+rfc2136BatchSize && i < len(updates); i++ {
+			for _, update := range updates[i] {
+				update(m)
+			}
+		}
+		c.sign(m)
+
+		resp, _, err := client.Exchange(m, c.server)
+		if err != nil {
+			return errors.Wrapf(err, "Could not send update to %v", c.server)
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("update rejected by %v: %v", c.server, dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	return nil
+}
+
+// wait is a no-op, since dynamic updates are applied by the server before it
+// responds.
+func (c *rfc2136Client) wait() error {
+	return nil
+}
+
+// hostToRR converts a host into a resource record, using ttl if the host
+// doesn't have a TTL of its own.
+func hostToRR(h hostEntry, ttl int64) dns.RR {
+	hdr := dns.RR_Header{
+		Name:  dns.Fqdn(h.hostname),
+		Class: dns.ClassINET,
+		Ttl:   uint32(h.recordTTL(ttl)),
+	}
+
+	switch h.recordType() {
+	case "CNAME":
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(h.target)}
+	case "PTR":
+		hdr.Rrtype = dns.TypePTR
+		return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(h.target)}
+	case "TXT":
+		hdr.Rrtype = dns.TypeTXT
+		return &dns.TXT{Hdr: hdr, Txt: []string{strings.Trim(h.target, "\"")}}
+	}
+
+	if ip4 := h.ip.To4(); ip4 != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip4}
+	}
+
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: h.ip}
+}
+
+// rrToHost converts a resource record into a host.  Only address, CNAME, PTR
+// and TXT records are supported, false is returned for any other record type.
+func rrToHost(rr dns.RR) (hostEntry, bool) {
+	h := hostEntry{
+		hostname: canonifyHostname(rr.Header().Name),
+		ttl:      int64(rr.Header().Ttl),
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		// Match the 16 byte form net.ParseIP produces for hosts files
+		h.ip = v.A.To16()
+	case *dns.AAAA:
+		h.ip = v.AAAA
+	case *dns.CNAME:
+		h.rtype = "CNAME"
+		h.target = canonifyHostname(v.Target)
+	case *dns.PTR:
+		h.rtype = "PTR"
+		h.target = canonifyHostname(v.Ptr)
+	case *dns.TXT:
+		// Stored in the quoted form Route 53 uses
+		h.rtype = "TXT"
+		h.target = "\"" + strings.Join(v.Txt, "") + "\""
+	default:
+		return h, false
+	}
+
+	return h, true
+}
+
+// convertRRsToHosts converts the records in a zone into hosts, ignoring any
+// record types we don't manage.
+func convertRRsToHosts(rrs []dns.RR) hostList {
+	hosts := hostList{}
+	for _, rr := range rrs {
+		if h, ok := rrToHost(rr); ok {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
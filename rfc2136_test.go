@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTSIGKey    = "test-key."
+	testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+// testDNSServer is a minimal authoritative server that supports zone
+// transfers and dynamic updates for a single zone, for testing the RFC 2136
+// provider without a real DNS server.
+type testDNSServer struct {
+	sync.Mutex
+	zone    string
+	records []dns.RR
+	updates int
+	server  *dns.Server
+	addr    string
+}
+
+func newTestDNSServer(t *testing.T, zone string, records ...string) *testDNSServer {
+	s := &testDNSServer{zone: dns.Fqdn(zone)}
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		assert.Nil(t, err)
+		s.records = append(s.records, rr)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s.addr = l.Addr().String()
+
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		Listener:          l,
+		Handler:           s,
+		TsigSecret:        map[string]string{testTSIGKey: testTSIGSecret},
+		MsgAcceptFunc:     func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.server.ActivateAndServe()
+	<-started
+
+	return s
+}
+
+func (s *testDNSServer) soa() dns.RR {
+	rr, _ := dns.NewRR(s.zone + " 3600 IN SOA ns1." + s.zone + " admin." + s.zone + " 1 3600 600 86400 300")
+	return rr
+}
+
+func (s *testDNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.Lock()
+	defer s.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	switch {
+	case r.Opcode == dns.OpcodeUpdate:
+		s.updates++
+		for _, rr := range r.Ns {
+			s.applyUpdate(rr)
+		}
+	case len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeAXFR:
+		m.Answer = append(m.Answer, s.soa())
+		m.Answer = append(m.Answer, s.records...)
+		m.Answer = append(m.Answer, s.soa())
+	default:
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	}
+
+	m.SetTsig(testTSIGKey, dns.HmacSHA256, 300, time.Now().Unix())
+	w.WriteMsg(m)
+}
+
+// applyUpdate applies a single RR from the update section, as described in
+// RFC 2136 section 3.4.2.
+func (s *testDNSServer) applyUpdate(rr dns.RR) {
+	hdr := rr.Header()
+	switch hdr.Class {
+	case dns.ClassANY:
+		s.removeMatching(func(r dns.RR) bool {
+			return r.Header().Name == hdr.Name && r.Header().Rrtype == hdr.Rrtype
+		})
+	case dns.ClassNONE:
+		s.removeMatching(func(r dns.RR) bool {
+			r2 := dns.Copy(rr)
+			r2.Header().Class = dns.ClassINET
+			r2.Header().Ttl = r.Header().Ttl
+			return r.String() == r2.String()
+		})
+	default:
+		s.records = append(s.records, rr)
+	}
+}
+
+func (s *testDNSServer) removeMatching(match func(dns.RR) bool) {
+	kept := s.records[:0]
+	for _, r := range s.records {
+		if !match(r) {
+			kept = append(kept, r)
+		}
+	}
+	s.records = kept
+}
+
+func (s *testDNSServer) recordStrings() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	result := []string{}
+	for _, r := range s.records {
+		result = append(result, r.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func testRFC2136Client(addr string) *rfc2136Client {
+	return &rfc2136Client{
+		server:    addr,
+		keyName:   testTSIGKey,
+		secret:    testTSIGSecret,
+		algorithm: dns.HmacSHA256,
+		timeout:   5 * time.Second,
+	}
+}
+
+func TestRFC2136GetHosts(t *testing.T) {
+	s := newTestDNSServer(t, "test.com",
+		"test1.test.com. 300 IN A 1.2.3.4",
+		"test1.test.com. 300 IN AAAA 2001:db8::1",
+		"test2.test.com. 300 IN CNAME test1.test.com.",
+	)
+	defer s.server.Shutdown()
+
+	hosts, err := testRFC2136Client(s.addr).getHosts("test.com")
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 300},
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1"), ttl: 300},
+		{hostname: "test2.test.com", rtype: "CNAME", target: "test1.test.com", ttl: 300},
+	}, hosts)
+}
+
+func TestRFC2136Sync(t *testing.T) {
+	s := newTestDNSServer(t, "test.com",
+		"test1.test.com. 300 IN A 1.2.3.4",
+		"test2.test.com. 300 IN A 1.2.3.5",
+		"test3.test.com. 300 IN A 1.2.3.6",
+	)
+	defer s.server.Shutdown()
+
+	c := testRFC2136Client(s.addr)
+	toUpdate := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.7")},
+		{hostname: "test4.test.com", ip: net.ParseIP("2001:db8::4")},
+		{hostname: "www.test.com", rtype: "CNAME", target: "test1.test.com"},
+	}
+	toDelete := hostList{
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+	assert.Nil(t, c.sync("test.com", 600, toUpdate, toDelete))
+	assert.Nil(t, c.wait())
+
+	assert.Equal(t, []string{
+		"test1.test.com.\t600\tIN\tA\t1.2.3.7",
+		"test3.test.com.\t300\tIN\tA\t1.2.3.6",
+		"test4.test.com.\t600\tIN\tAAAA\t2001:db8::4",
+		"www.test.com.\t600\tIN\tCNAME\ttest1.test.com.",
+	}, s.recordStrings())
+	assert.Equal(t, 1, s.updates)
+}
+
+func TestRFC2136SyncRejectsBadKey(t *testing.T) {
+	s := newTestDNSServer(t, "test.com")
+	defer s.server.Shutdown()
+
+	c := testRFC2136Client(s.addr)
+	c.secret = "d3JvbmdzZWNyZXQ="
+	err := c.sync("test.com", 600, hostList{{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")}}, hostList{})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{}, s.recordStrings())
+}
+
+func TestRFC2136SyncMultiValue(t *testing.T) {
+	s := newTestDNSServer(t, "test.com",
+		"test1.test.com. 300 IN A 1.2.3.4",
+	)
+	defer s.server.Shutdown()
+
+	c := testRFC2136Client(s.addr)
+	toUpdate := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4")},
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.5")},
+	}
+	assert.Nil(t, c.sync("test.com", 600, toUpdate, hostList{}))
+
+	assert.Equal(t, []string{
+		"test1.test.com.\t600\tIN\tA\t1.2.3.4",
+		"test1.test.com.\t600\tIN\tA\t1.2.3.5",
+	}, s.recordStrings())
+}
@@ -1,34 +1,72 @@
 package main
 
 import (
-	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/pkg/errors"
 )
 
+// errZoneNotFound is returned (wrapped) by getZone when there is no hosted
+// zone for the domain.
+var errZoneNotFound = errors.New("zone not found")
+
 type route53Client struct {
 	sess *session.Session
-	svc  *route53.Route53
+	svc  route53iface.Route53API
+	// pending holds the IDs of submitted changes that haven't been waited on
+	pending []*string
 }
 
-func newRoute53() route53Client {
-	var r53 route53Client
+// awsSession is global so that we only read the config once and reuse it
+// across every job.
+var (
+	awsSession     *session.Session
+	awsSessionOnce sync.Once
+)
+
+func newRoute53() *route53Client {
+	r53 := &route53Client{}
 
-	// awsSession is global so that we only read the config once and reuse it
-	r53.sess = session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	awsSessionOnce.Do(func() {
+		awsSession = session.Must(session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		}))
+	})
+	r53.sess = awsSession
 
-	r53.svc = route53.New(r53.sess)
+	svc := route53.New(r53.sess)
+	svc.Handlers.Complete.PushBack(recordRoute53Metrics)
+	r53.svc = svc
 
 	return r53
 }
 
-func (r53 route53Client) getZone(domain string) (*route53.HostedZone, error) {
+// recordRoute53Metrics updates the Route 53 API metrics once a request has
+// finished, after any retries.
+func recordRoute53Metrics(r *request.Request) {
+	op := r.Operation.Name
+	route53Requests.inc(op)
+	route53Seconds.add(time.Since(r.Time).Seconds(), op)
+	if r.Error != nil {
+		code := "unknown"
+		if aerr, ok := r.Error.(awserr.Error); ok {
+			code = aerr.Code()
+		}
+		route53Errors.inc(op, code)
+	}
+}
+
+func (r53 *route53Client) getZone(domain string) (*route53.HostedZone, error) {
 	params := &route53.ListHostedZonesByNameInput{
 		DNSName: aws.String(domain),
 	}
@@ -38,35 +76,87 @@ func (r53 route53Client) getZone(domain string) (*route53.HostedZone, error) {
 	}
 
 	if len(resp.HostedZones) == 0 {
-		return nil, fmt.Errorf("could not find domain '%v'", domain)
+		return nil, errors.Wrapf(errZoneNotFound, "could not find domain '%v'", domain)
 	}
 
 	if *resp.HostedZones[0].Name != (domain + ".") {
-		return nil, fmt.Errorf("could not find domain '%v'", domain)
+		return nil, errors.Wrapf(errZoneNotFound, "could not find domain '%v'", domain)
 	}
 
 	return resp.HostedZones[0], nil
 }
 
-func (r53 route53Client) getRecords(zid string) ([]*route53.ResourceRecordSet, error) {
+// findZone looks for the hosted zone containing name, trying each parent
+// domain in turn until one is found.
+func (r53 *route53Client) findZone(name string) (string, error) {
+	labels := strings.Split(canonifyHostname(name), ".")
+	for i := range labels {
+		domain := strings.Join(labels[i:], ".")
+		_, err := r53.getZone(domain)
+		if err == nil {
+			return domain, nil
+		}
+		if errors.Cause(err) != errZoneNotFound {
+			return "", err
+		}
+	}
+
+	return "", errors.Wrapf(errZoneNotFound, "could not find a zone for '%v'", name)
+}
+
+// getRecords returns the record sets in the given zone, following
+// pagination until the whole zone has been read.  If name is non-empty only
+// record sets with that name are returned, and if rtype is also non-empty the
+// results are further limited to that record type.
+func (r53 *route53Client) getRecords(zid string, name string, rtype string) ([]*route53.ResourceRecordSet, error) {
 	params := &route53.ListResourceRecordSetsInput{
 		HostedZoneId: &zid,
 	}
-	resp, err := r53.svc.ListResourceRecordSets(params)
-	if err != nil {
-		return nil, errors.Wrap(err, "Cannot get records")
+	if name != "" {
+		params.StartRecordName = aws.String(name)
+		if rtype != "" {
+			params.StartRecordType = aws.String(rtype)
+		}
+	}
+
+	records := []*route53.ResourceRecordSet{}
+	for {
+		resp, err := r53.svc.ListResourceRecordSets(params)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot get records")
+		}
+
+		for _, rrset := range resp.ResourceRecordSets {
+			// Results are returned in sorted order starting at the requested
+			// name and type, so once we see something else we're done.
+			if name != "" && canonifyHostname(*rrset.Name) != canonifyHostname(name) {
+				return records, nil
+			}
+			if rtype != "" && *rrset.Type != rtype {
+				return records, nil
+			}
+			records = append(records, rrset)
+		}
+
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			break
+		}
+
+		params.StartRecordName = resp.NextRecordName
+		params.StartRecordType = resp.NextRecordType
+		params.StartRecordIdentifier = resp.NextRecordIdentifier
 	}
 
-	return resp.ResourceRecordSets, nil
+	return records, nil
 }
 
-func (r53 route53Client) getHosts(domain string) (hostList, error) {
+func (r53 *route53Client) getHosts(domain string) (hostList, error) {
 	zone, err := r53.getZone(domain)
 	if err != nil {
 		return hostList{}, errors.Wrap(err, "Cannot get zone")
 	}
 
-	rawHosts, err := r53.getRecords(*zone.Id)
+	rawHosts, err := r53.getRecords(*zone.Id, "", "")
 	if err != nil {
 		return hostList{}, errors.Wrap(err, "Cannot get hosts")
 	}
@@ -74,29 +164,41 @@ func (r53 route53Client) getHosts(domain string) (hostList, error) {
 	return convertR53RecordsToHosts(rawHosts), nil
 }
 
-func (r53 route53Client) sync(domain string, ttl int64, wait bool, toUpdate []hostEntry, toDelete []hostEntry) error {
+func (r53 *route53Client) sync(domain string, ttl int64, toUpdate hostList, toDelete hostList) error {
 	zone, err := r53.getZone(domain)
 	if err != nil {
 		return errors.Wrap(err, "Cannot get zone")
 	}
 
 	changes := make([]*route53.Change, 0, len(toUpdate)+len(toDelete))
-	for _, h := range toUpdate {
+	// Hosts with the same name and type are a single record set
+	groups, keys := groupByKey(toUpdate)
+	for _, key := range keys {
+		first := groups[key][0]
+		rrset := &route53.ResourceRecordSet{
+			Name: aws.String(first.hostname),
+			Type: aws.String(first.recordType()),
+			TTL:  aws.Int64(groups[key].ttl(ttl)),
+		}
+		for _, value := range groups[key].values() {
+			rrset.ResourceRecords = append(rrset.ResourceRecords,
+				&route53.ResourceRecord{Value: aws.String(value)})
+		}
 		change := route53.Change{
-			Action: aws.String("UPSERT"),
-			ResourceRecordSet: &route53.ResourceRecordSet{
-				Name: aws.String(h.hostname),
-				Type: aws.String("A"),
-				TTL:  aws.Int64(ttl),
-				ResourceRecords: []*route53.ResourceRecord{
-					{Value: aws.String(h.ip.String())},
-				},
-			},
+			Action:            aws.String("UPSERT"),
+			ResourceRecordSet: rrset,
 		}
 		changes = append(changes, &change)
 	}
 
+	// Every host from a multi-value record set shares the same rrset, which
+	// only needs to be deleted once.
+	deleted := map[*route53.ResourceRecordSet]bool{}
 	for _, h := range toDelete {
+		if deleted[h.rrset] {
+			continue
+		}
+		deleted[h.rrset] = true
 		change := route53.Change{
 			Action:            aws.String("DELETE"),
 			ResourceRecordSet: h.rrset,
@@ -104,69 +206,194 @@ func (r53 route53Client) sync(domain string, ttl int64, wait bool, toUpdate []ho
 		changes = append(changes, &change)
 	}
 
-	input := route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: zone.Id,
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: changes,
-		},
-	}
+	batches := batchChanges(changes)
+	for i, batch := range batches {
+		input := route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: zone.Id,
+			ChangeBatch: &route53.ChangeBatch{
+				Changes: batch,
+			},
+		}
 
-	log.Debug("Changeset for Route 53:")
-	log.Debug(input)
-	log.Infof("Adding/updating %v records, deleting %v out of date records",
-		len(toUpdate), len(toDelete))
+		log.Debugf("Changeset for Route 53 (batch %d of %d):", i+1, len(batches))
+		log.Debug(input)
+		log.Infof("Submitting batch %d of %d (%d changes)", i+1, len(batches), len(batch))
 
-	resp, err := r53.svc.ChangeResourceRecordSets(&input)
-	if err != nil {
-		return errors.Wrapf(err, "Could not update Route 53 records")
+		resp, err := r53.svc.ChangeResourceRecordSets(&input)
+		if err != nil {
+			if i > 0 {
+				log.Errorf("Batch %d of %d failed, batches 1-%d were already applied",
+					i+1, len(batches), i)
+			} else {
+				log.Errorf("Batch 1 of %d failed, no changes were applied", len(batches))
+			}
+			return errors.Wrapf(err, "Could not update Route 53 records (batch %d of %d)",
+				i+1, len(batches))
+		}
+		r53.pending = append(r53.pending, resp.ChangeInfo.Id)
 	}
 
-	if wait {
-		log.Info("Waiting for Route 53 update to complete")
+	return nil
+}
+
+func (r53 *route53Client) wait() error {
+	for len(r53.pending) > 0 {
 		gci := route53.GetChangeInput{
-			Id: resp.ChangeInfo.Id,
+			Id: r53.pending[0],
 		}
-		err = r53.svc.WaitUntilResourceRecordSetsChanged(&gci)
-		if err != nil {
-			return errors.Wrapf(err, "Update failed during wait")
+		if err := r53.svc.WaitUntilResourceRecordSetsChanged(&gci); err != nil {
+			return errors.Wrapf(err, "Route 53 change %v did not complete", *gci.Id)
 		}
-		log.Info("Sync completed successfully")
-	} else {
-		log.Info("Sync queued for update")
+		r53.pending = r53.pending[1:]
 	}
 
 	return nil
 }
 
+func (r53 *route53Client) pendingChanges() []string {
+	return aws.StringValueSlice(r53.pending)
+}
+
+// Route 53 limits on a single ChangeResourceRecordSets request.  UPSERT
+// changes count double against both limits.
+const (
+	maxBatchRecords    = 1000
+	maxBatchValueChars = 32000
+)
+
+// changeSize returns how many ResourceRecord elements and how many characters
+// of record values a change counts as against the Route 53 batch limits.
+func changeSize(change *route53.Change) (records int, chars int) {
+	for _, rr := range change.ResourceRecordSet.ResourceRecords {
+		records++
+		chars += len(aws.StringValue(rr.Value))
+	}
+
+	if aws.StringValue(change.Action) == "UPSERT" {
+		return records * 2, chars * 2
+	}
+	return records, chars
+}
+
+// batchChanges sorts changes into a deterministic order and splits them into
+// batches that fit within the Route 53 limits.  Changes for the same name are
+// never split across batches, and deletes are ordered before upserts.
+func batchChanges(changes []*route53.Change) [][]*route53.Change {
+	sorted := make([]*route53.Change, len(changes))
+	copy(sorted, changes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].ResourceRecordSet, sorted[j].ResourceRecordSet
+		if *a.Name != *b.Name {
+			return *a.Name < *b.Name
+		}
+		if *sorted[i].Action != *sorted[j].Action {
+			return *sorted[i].Action == "DELETE"
+		}
+		return *a.Type < *b.Type
+	})
+
+	batches := [][]*route53.Change{}
+	batch := []*route53.Change{}
+	batchRecords, batchChars := 0, 0
+	for i := 0; i < len(sorted); {
+		// Collect every change for this name into a single group
+		j := i
+		groupRecords, groupChars := 0, 0
+		for ; j < len(sorted) && *sorted[j].ResourceRecordSet.Name == *sorted[i].ResourceRecordSet.Name; j++ {
+			records, chars := changeSize(sorted[j])
+			groupRecords += records
+			groupChars += chars
+		}
+
+		if len(batch) > 0 && (batchRecords+groupRecords > maxBatchRecords ||
+			batchChars+groupChars > maxBatchValueChars) {
+			batches = append(batches, batch)
+			batch = []*route53.Change{}
+			batchRecords, batchChars = 0, 0
+		}
+
+		batch = append(batch, sorted[i:j]...)
+		batchRecords += groupRecords
+		batchChars += groupChars
+		i = j
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// convertR53RecordsToHosts converts Route 53 record sets into hosts.  Address
+// record sets with several values produce one host per value, all sharing
+// the same rrset.  TXT records are only needed to track ownership, so they
+// are only read with --owner-id.
 func convertR53RecordsToHosts(rawHosts []*route53.ResourceRecordSet) hostList {
 	hosts := hostList{}
 	for _, rh := range rawHosts {
-		if *rh.Type != "A" {
+		if *rh.Type != "A" && *rh.Type != "AAAA" && *rh.Type != "CNAME" && *rh.Type != "PTR" &&
+			(*rh.Type != "TXT" || opts.OwnerID == "") {
+			log.Debugf("%v is a %v record, ignoring record", *rh.Name, *rh.Type)
 			continue
 		}
 
-		if len(rh.ResourceRecords) > 1 {
-			log.Warnf("%v has too many resource records (%d), ignoring record",
-				*rh.Name, len(rh.ResourceRecords))
-			continue
-		}
 		if len(rh.ResourceRecords) == 0 {
 			log.Debugf("%v has no resource records, ignoring record", *rh.Name)
 			continue
 		}
-		ip := net.ParseIP(*rh.ResourceRecords[0].Value)
-		if ip == nil {
-			log.Warnf("cannot parse IP %v for %v, ignoring record",
-				*rh.ResourceRecords[0].Value, *rh.Name)
+		switch *rh.Type {
+		case "CNAME", "PTR", "TXT":
+			if len(rh.ResourceRecords) > 1 {
+				// Ownership records only ever have one value, so TXT
+				// records like this are nothing to do with us
+				logf := log.Warnf
+				if *rh.Type == "TXT" {
+					logf = log.Debugf
+				}
+				logf("%v has too many resource records (%d), ignoring record",
+					*rh.Name, len(rh.ResourceRecords))
+				continue
+			}
+			target := *rh.ResourceRecords[0].Value
+			if *rh.Type != "TXT" {
+				target = canonifyHostname(target)
+			}
+			hosts = append(hosts, hostEntry{
+				hostname: canonifyHostname(*rh.Name),
+				rtype:    *rh.Type,
+				target:   target,
+				ttl:      aws.Int64Value(rh.TTL),
+				rrset:    rh,
+			})
 			continue
 		}
-		host := hostEntry{
-			hostname: canonifyHostname(*rh.Name),
-			ip:       ip,
-			rrset:    rh,
+
+		set := hostList{}
+		for _, rr := range rh.ResourceRecords {
+			ip := net.ParseIP(*rr.Value)
+			if ip == nil {
+				log.Warnf("cannot parse IP %v for %v, ignoring record",
+					*rr.Value, *rh.Name)
+				set = nil
+				break
+			}
+			host := hostEntry{
+				hostname: canonifyHostname(*rh.Name),
+				ip:       ip,
+				ttl:      aws.Int64Value(rh.TTL),
+				rrset:    rh,
+			}
+			if host.recordType() != *rh.Type {
+				log.Warnf("%v record for %v contains %v, ignoring record",
+					*rh.Type, *rh.Name, *rr.Value)
+				set = nil
+				break
+			}
+			set = append(set, host)
 		}
 
-		hosts = append(hosts, host)
+		hosts = append(hosts, set...)
 	}
 
 	return hosts
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,16 +47,345 @@ func TestConvertR53RecordsToHosts(t *testing.T) {
 				{Value: aws.String("abc")},
 			},
 		},
+		{
+			Name: aws.String("test5.test.com"),
+			Type: aws.String("AAAA"),
+			TTL:  aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String("2001:db8::1")},
+			},
+		},
+		{
+			Name: aws.String("test6.test.com"),
+			Type: aws.String("AAAA"),
+			TTL:  aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String("1.2.3.4")},
+			},
+		},
 	}
 
-	expected := hostList{{
-		hostname: "test1.test.com",
-		ip:       net.ParseIP("1.2.3.4"),
-		rrset:    input[0],
-	},
+	expected := hostList{
+		{
+			hostname: "test1.test.com",
+			ip:       net.ParseIP("1.2.3.4"),
+			ttl:      300,
+			rrset:    input[0],
+		},
+		{
+			hostname: "test2.test.com",
+			rtype:    "CNAME",
+			target:   "test1.test.com",
+			ttl:      300,
+			rrset:    input[1],
+		},
+		{
+			hostname: "test3.test.com",
+			ip:       net.ParseIP("1.2.3.4"),
+			ttl:      300,
+			rrset:    input[2],
+		},
+		{
+			hostname: "test3.test.com",
+			ip:       net.ParseIP("1.2.3.5"),
+			ttl:      300,
+			rrset:    input[2],
+		},
+		{
+			hostname: "test5.test.com",
+			ip:       net.ParseIP("2001:db8::1"),
+			ttl:      300,
+			rrset:    input[4],
+		},
 	}
 
 	output := convertR53RecordsToHosts(input)
 	assert.Equal(t, expected, output)
 
+	// TXT records are only read back to track ownership
+	defer func(ownerID string) { opts.OwnerID = ownerID }(opts.OwnerID)
+	txt := []*route53.ResourceRecordSet{
+		{
+			Name: aws.String("_sync-hosts.a.test1.test.com"),
+			Type: aws.String("TXT"),
+			TTL:  aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String(registryValue("test"))},
+			},
+		},
+		{
+			Name: aws.String("test.com"),
+			Type: aws.String("TXT"),
+			TTL:  aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String(`"v=spf1 -all"`)},
+				{Value: aws.String(`"verification=abc"`)},
+			},
+		},
+	}
+
+	opts.OwnerID = ""
+	assert.Equal(t, hostList{}, convertR53RecordsToHosts(txt))
+
+	opts.OwnerID = "test"
+	assert.Equal(t, hostList{
+		{
+			hostname: "_sync-hosts.a.test1.test.com",
+			rtype:    "TXT",
+			target:   registryValue("test"),
+			ttl:      300,
+			rrset:    txt[0],
+		},
+	}, convertR53RecordsToHosts(txt))
+}
+
+// fakeRoute53 implements just enough of the Route 53 API to test the client
+// without talking to AWS.  Any method not overridden here will panic.
+type fakeRoute53 struct {
+	route53iface.Route53API
+	records  []*route53.ResourceRecordSet
+	pageSize int
+	calls    int
+	// batches records every change batch submitted.  If failBatch is
+	// non-zero, that batch (counting from one) returns an error.
+	batches   [][]*route53.Change
+	failBatch int
+	// zones limits which hosted zones exist.  If nil, every zone exists.
+	zones []string
+}
+
+func (f *fakeRoute53) ListHostedZonesByName(input *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error) {
+	if f.zones != nil {
+		output := &route53.ListHostedZonesByNameOutput{}
+		for _, z := range f.zones {
+			if z == *input.DNSName {
+				output.HostedZones = append(output.HostedZones,
+					&route53.HostedZone{Id: aws.String("Z1"), Name: aws.String(z + ".")})
+			}
+		}
+		return output, nil
+	}
+
+	return &route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("Z1"), Name: aws.String(*input.DNSName + ".")},
+		},
+	}, nil
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.batches = append(f.batches, input.ChangeBatch.Changes)
+	if len(f.batches) == f.failBatch {
+		return nil, errors.New("rate exceeded")
+	}
+
+	return &route53.ChangeResourceRecordSetsOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id: aws.String(fmt.Sprintf("C%d", len(f.batches))),
+		},
+	}, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	f.calls++
+
+	start := 0
+	if input.StartRecordName != nil {
+		for start < len(f.records) {
+			rrset := f.records[start]
+			if *rrset.Name == *input.StartRecordName &&
+				(input.StartRecordType == nil || *rrset.Type == *input.StartRecordType) {
+				break
+			}
+			start++
+		}
+	}
+
+	end := start + f.pageSize
+	if end > len(f.records) {
+		end = len(f.records)
+	}
+
+	output := &route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets: f.records[start:end],
+		IsTruncated:        aws.Bool(end < len(f.records)),
+	}
+	if end < len(f.records) {
+		output.NextRecordName = f.records[end].Name
+		output.NextRecordType = f.records[end].Type
+	}
+
+	return output, nil
+}
+
+func fakeRecords(names ...string) []*route53.ResourceRecordSet {
+	records := []*route53.ResourceRecordSet{}
+	for i, name := range names {
+		records = append(records, &route53.ResourceRecordSet{
+			Name: aws.String(name),
+			Type: aws.String("A"),
+			TTL:  aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String(net.IPv4(10, 0, 0, byte(i)).String())},
+			},
+		})
+	}
+	return records
+}
+
+func TestGetRecordsPaginates(t *testing.T) {
+	fake := &fakeRoute53{
+		records:  fakeRecords("a.test.com.", "b.test.com.", "c.test.com.", "d.test.com.", "e.test.com."),
+		pageSize: 2,
+	}
+	r53 := &route53Client{svc: fake}
+
+	records, err := r53.getRecords("Z1", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, fake.records, records)
+	assert.Equal(t, 3, fake.calls)
+}
+
+func TestGetRecordsScopedByName(t *testing.T) {
+	fake := &fakeRoute53{
+		records:  fakeRecords("a.test.com.", "b.test.com.", "b.test.com.", "b.test.com.", "c.test.com."),
+		pageSize: 2,
+	}
+	fake.records[2].Type = aws.String("AAAA")
+	fake.records[2].ResourceRecords[0].Value = aws.String("2001:db8::1")
+	fake.records[3].Type = aws.String("TXT")
+	r53 := &route53Client{svc: fake}
+
+	records, err := r53.getRecords("Z1", "b.test.com.", "")
+	assert.Nil(t, err)
+	assert.Equal(t, fake.records[1:4], records)
+
+	records, err = r53.getRecords("Z1", "b.test.com.", "AAAA")
+	assert.Nil(t, err)
+	assert.Equal(t, fake.records[2:3], records)
+}
+
+func testChange(action string, name string, rtype string, values int) *route53.Change {
+	rrset := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String(rtype),
+		TTL:  aws.Int64(300),
+	}
+	for i := 0; i < values; i++ {
+		rrset.ResourceRecords = append(rrset.ResourceRecords,
+			&route53.ResourceRecord{Value: aws.String(net.IPv4(10, 0, 0, byte(i)).String())})
+	}
+	return &route53.Change{Action: aws.String(action), ResourceRecordSet: rrset}
+}
+
+func TestBatchChangesOrdering(t *testing.T) {
+	changes := []*route53.Change{
+		testChange("UPSERT", "b.test.com", "A", 1),
+		testChange("UPSERT", "a.test.com", "AAAA", 1),
+		testChange("DELETE", "b.test.com", "AAAA", 1),
+		testChange("UPSERT", "a.test.com", "A", 1),
+	}
+
+	batches := batchChanges(changes)
+	assert.Equal(t, [][]*route53.Change{
+		{changes[3], changes[1], changes[2], changes[0]},
+	}, batches)
+}
+
+func TestBatchChangesSplits(t *testing.T) {
+	changes := []*route53.Change{}
+	for i := 0; i < 1200; i++ {
+		changes = append(changes, testChange("UPSERT", fmt.Sprintf("host%04d.test.com", i), "A", 1))
+	}
+	// A delete and upsert for the same name straddling the batch boundary
+	// must stay together.
+	changes = append(changes, testChange("DELETE", "host0499.test.com", "AAAA", 1))
+
+	batches := batchChanges(changes)
+	assert.Equal(t, 3, len(batches))
+	assert.Equal(t, 499, len(batches[0]))
+	assert.Equal(t, "host0499.test.com", *batches[1][0].ResourceRecordSet.Name)
+	assert.Equal(t, "DELETE", *batches[1][0].Action)
+	assert.Equal(t, "UPSERT", *batches[1][1].Action)
+
+	total := 0
+	for _, batch := range batches {
+		records, chars := 0, 0
+		for _, c := range batch {
+			r, ch := changeSize(c)
+			records += r
+			chars += ch
+		}
+		assert.True(t, records <= maxBatchRecords)
+		assert.True(t, chars <= maxBatchValueChars)
+		total += len(batch)
+	}
+	assert.Equal(t, len(changes), total)
+}
+
+func TestSyncReportsFailedBatch(t *testing.T) {
+	fake := &fakeRoute53{failBatch: 2}
+	r53 := &route53Client{svc: fake}
+
+	toUpdate := hostList{}
+	for i := 0; i < 1200; i++ {
+		toUpdate = append(toUpdate, hostEntry{
+			hostname: fmt.Sprintf("host%04d.test.com", i),
+			ip:       net.IPv4(10, 0, byte(i/256), byte(i%256)),
+		})
+	}
+
+	err := r53.sync("test.com", 300, toUpdate, hostList{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "batch 2 of 3")
+	assert.Equal(t, 2, len(fake.batches))
+}
+
+func TestSyncGroupsMultiValueSets(t *testing.T) {
+	fake := &fakeRoute53{}
+	r53 := &route53Client{svc: fake}
+
+	rrset := fakeRecords("old.test.com.")[0]
+	toUpdate := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("10.0.0.2")},
+		{hostname: "test1.test.com", ip: net.ParseIP("10.0.0.1")},
+		{hostname: "test2.test.com", ip: net.ParseIP("10.0.0.3"), ttl: 60},
+	}
+	toDelete := hostList{
+		{hostname: "old.test.com", ip: net.ParseIP("10.0.0.0"), rrset: rrset},
+		{hostname: "old.test.com", ip: net.ParseIP("10.0.0.1"), rrset: rrset},
+	}
+
+	err := r53.sync("test.com", 300, toUpdate, toDelete)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(fake.batches))
+
+	changes := fake.batches[0]
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "DELETE", *changes[0].Action)
+	assert.Equal(t, rrset, changes[0].ResourceRecordSet)
+	assert.Equal(t, "test1.test.com", *changes[1].ResourceRecordSet.Name)
+	assert.Equal(t, []*route53.ResourceRecord{
+		{Value: aws.String("10.0.0.1")},
+		{Value: aws.String("10.0.0.2")},
+	}, changes[1].ResourceRecordSet.ResourceRecords)
+	assert.Equal(t, int64(300), *changes[1].ResourceRecordSet.TTL)
+	assert.Equal(t, "test2.test.com", *changes[2].ResourceRecordSet.Name)
+	assert.Equal(t, int64(60), *changes[2].ResourceRecordSet.TTL)
+}
+
+func TestFindZone(t *testing.T) {
+	fake := &fakeRoute53{zones: []string{"20.10.in-addr.arpa", "test.com"}}
+	r53 := &route53Client{svc: fake}
+
+	zone, err := r53.findZone("4.1.20.10.in-addr.arpa")
+	assert.Nil(t, err)
+	assert.Equal(t, "20.10.in-addr.arpa", zone)
+
+	zone, err = r53.findZone("test1.test.com.")
+	assert.Nil(t, err)
+	assert.Equal(t, "test.com", zone)
+
+	_, err = r53.findZone("4.1.30.10.in-addr.arpa")
+	assert.NotNil(t, err)
 }
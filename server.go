@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// startHTTPServer serves the daemon's metrics and health checks on addr in
+// the background.  Errors listening on addr are returned straight away.
+func startHTTPServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.Handle("/healthz", healthHandler(false))
+	mux.Handle("/readyz", healthHandler(true))
+
+	return serve(addr, mux)
+}
+
+// startControlServer serves the control API on addr in the background.
+func startControlServer(addr string) error {
+	return serve(addr, controlHandler())
+}
+
+// serve serves handler on addr in the background.
+func serve(addr string, handler http.Handler) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Serving HTTP on ", ln.Addr())
+	go func() {
+		log.Fatal(http.Serve(ln, handler))
+	}()
+
+	return nil
+}
+
+// listen listens on a TCP address, or on a Unix socket given as unix:PATH.
+// A socket left behind by an earlier run is replaced.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the owner and group of the daemon can use the socket.  The umask
+	// is set around creating it, rather than changing the mode afterwards, so
+	// that it's never accessible to anyone else.  This is process wide, but
+	// the servers are started before any jobs that could be creating files.
+	oldmask := syscall.Umask(0117)
+	defer syscall.Umask(oldmask)
+
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncStatus is the outcome of the most recent sync of a job.
+type syncStatus struct {
+	started  time.Time
+	finished time.Time
+	err      error
+	plans    syncPlans
+	// pending holds the IDs of changes submitted but not waited on
+	pending []string
+	// lastSuccess is when the job last synced successfully, which may be
+	// before this sync
+	lastSuccess time.Time
+}
+
+// managed returns how many managed records there were when the plans were
+// computed, and how many of them were being updated and deleted.
+func (s syncStatus) managed() (current int, toUpdate int, toDelete int) {
+	for _, p := range s.plans {
+		current += len(p.current)
+		toUpdate += len(p.toUpdate)
+		toDelete += len(p.toDelete)
+	}
+	return
+}
+
+// records returns the managed records as of the sync.  If the sync succeeded
+// that includes the changes it made.
+func (s syncStatus) records() hostList {
+	records := hostList{}
+	for _, p := range s.plans {
+		if s.err != nil {
+			records = append(records, p.current...)
+			continue
+		}
+
+		// Updates replace the whole record set
+		replaced := map[string]bool{}
+		for _, h := range p.toUpdate {
+			replaced[h.key()] = true
+		}
+		deleted := map[string]bool{}
+		for _, h := range p.toDelete {
+			deleted[h.key()+"/"+h.value()] = true
+		}
+
+		for _, h := range p.current {
+			if !replaced[h.key()] && !deleted[h.key()+"/"+h.value()] {
+				records = append(records, h)
+			}
+		}
+		records = append(records, p.toUpdate...)
+	}
+	return records
+}
+
+var (
+	statusMu   sync.Mutex
+	statuses   = map[string]syncStatus{}
+	heartbeats = map[string]time.Time{}
+	// daemonJobs holds the jobs the daemon is running, and daemonResyncs the
+	// channels used to make each of them sync now
+	daemonJobs    []syncJob
+	daemonResyncs = map[string]chan struct{}{}
+)
+
+// recordStatus saves the outcome of a sync of job.
+func recordStatus(job syncJob, status syncStatus) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if status.err == nil {
+		status.lastSuccess = status.finished
+	} else {
+		status.lastSuccess = statuses[job.String()].lastSuccess
+	}
+	statuses[job.String()] = status
+}
+
+// recordHeartbeat notes that the loop running job was alive at now.
+func recordHeartbeat(job syncJob, now time.Time) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	heartbeats[job.String()] = now
+}
+
+// jobHeartbeat returns when the loop running job was last alive, and false if
+// it hasn't started yet.
+func jobHeartbeat(job syncJob) (time.Time, bool) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	heartbeat, ok := heartbeats[job.String()]
+	return heartbeat, ok
+}
+
+// setDaemonJobs records the jobs the daemon is running, along with the
+// channel used to make each of them sync now.
+func setDaemonJobs(jobs []syncJob, resyncs []chan struct{}) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	daemonJobs = jobs
+	daemonResyncs = map[string]chan struct{}{}
+	for i, resync := range resyncs {
+		daemonResyncs[jobs[i].String()] = resync
+	}
+}
+
+// requestResync makes each of jobs sync now.
+func requestResync(jobs []syncJob) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for _, job := range jobs {
+		// A resync that is already queued covers this one too
+		select {
+		case daemonResyncs[job.String()] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runningJobs returns the jobs the daemon is running.
+func runningJobs() []syncJob {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return daemonJobs
+}
+
+// jobStatus returns the outcome of the most recent sync of job, and false if
+// it hasn't been synced yet.
+func jobStatus(job syncJob) (syncStatus, bool) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status, ok := statuses[job.String()]
+	return status, ok
+}
+
+// logStatus logs a report on the most recent sync of each job.
+func logStatus(jobs []syncJob) {
+	for _, job := range jobs {
+		status, ok := jobStatus(job)
+		if !ok {
+			log.Infof("Status of %v: not synced yet", job)
+			continue
+		}
+
+		result := "succeeded"
+		if status.err != nil {
+			result = "failed: " + status.err.Error()
+		}
+		current, toUpdate, toDelete := status.managed()
+		pending := "none"
+		if len(status.pending) > 0 {
+			pending = strings.Join(status.pending, ", ")
+		}
+
+		log.Infof("Status of %v: last sync at %v took %v and %v", job,
+			status.finished.Format(time.RFC3339), status.finished.Sub(status.started), result)
+		log.Infof("Status of %v: %d managed records, %d added/updated, %d deleted, pending changes: %v",
+			job, current, toUpdate, toDelete, pending)
+	}
+}
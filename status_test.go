@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resetStatus forgets the status and heartbeat of every job, so that tests
+// don't see each other's results, or their own from an earlier run.
+func resetStatus() {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	statuses = map[string]syncStatus{}
+	heartbeats = map[string]time.Time{}
+}
+
+func TestSyncStatus(t *testing.T) {
+	resetStatus()
+	job := syncJob{file: "/etc/hosts", domain: "status.test.com"}
+	_, ok := jobStatus(job)
+	assert.False(t, ok)
+
+	status := syncStatus{
+		err: errors.New("rate exceeded"),
+		plans: syncPlans{
+			{
+				domain:   "status.test.com",
+				toUpdate: hostList{{hostname: "test1.status.test.com", ip: net.ParseIP("1.2.3.4")}},
+				current: hostList{
+					{hostname: "test2.status.test.com", ip: net.ParseIP("1.2.3.5")},
+					{hostname: "test3.status.test.com", ip: net.ParseIP("1.2.3.6")},
+				},
+			},
+			{
+				domain:   "3.2.1.in-addr.arpa",
+				toDelete: hostList{{hostname: "6.3.2.1.in-addr.arpa", rtype: "PTR", target: "test3.status.test.com"}},
+				current:  hostList{{hostname: "6.3.2.1.in-addr.arpa", rtype: "PTR", target: "test3.status.test.com"}},
+			},
+		},
+		pending: []string{"C1"},
+	}
+	recordStatus(job, status)
+
+	saved, ok := jobStatus(job)
+	assert.True(t, ok)
+	assert.Equal(t, status, saved)
+
+	current, toUpdate, toDelete := saved.managed()
+	assert.Equal(t, 3, current)
+	assert.Equal(t, 1, toUpdate)
+	assert.Equal(t, 1, toDelete)
+}
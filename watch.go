@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rjeczalik/notify"
+)
+
+// watcher reports changes to a single file.
+type watcher interface {
+	// changes returns a channel that receives a description of each change.
+	changes() <-chan string
+	stop()
+}
+
+// newWatcher watches filename for changes, using inotify or polling as
+// selected with --watch.  In auto mode polling is used if inotify can't be
+// set up.
+func newWatcher(filename string) watcher {
+	// notify reports absolute paths, so a relative one would never match
+	absfn, err := filepath.Abs(filename)
+	if err != nil {
+		log.Fatalf("Cannot convert %v to absolute path: %v", filename, err)
+	}
+	filename = absfn
+
+	if opts.Watch != "poll" {
+		w, err := newNotifyWatcher(filename)
+		if err == nil {
+			return w
+		}
+		if opts.Watch == "notify" {
+			log.Fatal(err)
+		}
+		log.Warn(err, ", falling back to polling")
+	}
+
+	return newPollWatcher(filename, opts.PollInterval)
+}
+
+// notifyWatcher watches the directory holding a file with inotify (or the
+// platform equivalent), and passes on the events for that file.
+type notifyWatcher struct {
+	cn   chan notify.EventInfo
+	out  chan string
+	done chan struct{}
+}
+
+func newNotifyWatcher(filename string) (*notifyWatcher, error) {
+	dir := filepath.Dir(filename)
+	basename := filepath.Base(filename)
+	// Events are reported with any symlinks in the directory resolved
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		filename = filepath.Join(resolved, basename)
+	}
+
+	w := &notifyWatcher{
+		cn:   make(chan notify.EventInfo, 1),
+		out:  make(chan string, 1),
+		done: make(chan struct{}),
+	}
+	if err := notify.Watch(dir, w.cn, notify.All); err != nil {
+		return nil, errors.Wrapf(err, "Cannot setup watch for %v", dir)
+	}
+
+	log.Infof("Watching %v for changes to %v", dir, basename)
+
+	go func() {
+		defer close(w.out)
+		for {
+			var ei notify.EventInfo
+			select {
+			case <-w.done:
+				return
+			case ei = <-w.cn:
+			}
+
+			if ei.Path() == filename {
+				// Drop the event if one is already waiting, so that this
+				// never blocks once the watcher is stopped
+				select {
+				case w.out <- fmt.Sprint(ei):
+				default:
+				}
+			} else if debugLogging() {
+				log.Debug("file change event detected: ", ei)
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *notifyWatcher) changes() <-chan string {
+	return w.out
+}
+
+// stop stops the watch.  cn is left open, since notify may still be sending
+// an event on it.
+func (w *notifyWatcher) stop() {
+	notify.Stop(w.cn)
+	close(w.done)
+}
+
+// fileState is what the poll watcher compares to spot changes.
+type fileState struct {
+	exists bool
+	size   int64
+	mtime  time.Time
+	inode  uint64
+	hash   []byte
+}
+
+func (s fileState) equal(other fileState) bool {
+	return s.exists == other.exists && s.size == other.size && s.mtime.Equal(other.mtime) &&
+		s.inode == other.inode && bytes.Equal(s.hash, other.hash)
+}
+
+// statFile returns the current state of filename.  Errors reading the file
+// are treated the same as the file not existing.
+func statFile(filename string) fileState {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fileState{}
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fileState{}
+	}
+
+	state := fileState{exists: true, size: fi.Size(), mtime: fi.ModTime()}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		state.inode = uint64(st.Ino)
+	}
+
+	// The hash catches changes that keep the same size within the mtime
+	// resolution of the filesystem.
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fileState{}
+	}
+	state.hash = h.Sum(nil)
+
+	return state
+}
+
+// pollWatcher checks a file for changes on an interval, for platforms where
+// inotify isn't available or doesn't work.
+type pollWatcher struct {
+	out  chan string
+	done chan struct{}
+}
+
+func newPollWatcher(filename string, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		out:  make(chan string, 1),
+		done: make(chan struct{}),
+	}
+
+	log.Infof("Polling %v for changes every %v", filename, interval)
+
+	last := statFile(filename)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				close(w.out)
+				return
+			case <-ticker.C:
+			}
+
+			state := statFile(filename)
+			if state.equal(last) {
+				continue
+			}
+
+			change := filename + ": modified"
+			if !state.exists {
+				change = filename + ": removed"
+			} else if !last.exists {
+				change = filename + ": created"
+			}
+			last = state
+
+			// Drop the change if one is already waiting
+			select {
+			case w.out <- change:
+			default:
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *pollWatcher) changes() <-chan string {
+	return w.out
+}
+
+func (w *pollWatcher) stop() {
+	close(w.done)
+}
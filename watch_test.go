@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "hosts")
+
+	assert.False(t, statFile(fn).exists)
+
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.1 foo\n"), 0644))
+	first := statFile(fn)
+	assert.True(t, first.exists)
+	assert.Equal(t, int64(13), first.size)
+	assert.True(t, first.equal(statFile(fn)))
+
+	// Same size and modification time, so only the hash tells them apart
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.2 foo\n"), 0644))
+	assert.Nil(t, os.Chtimes(fn, first.mtime, first.mtime))
+	second := statFile(fn)
+	assert.Equal(t, first.size, second.size)
+	assert.True(t, first.mtime.Equal(second.mtime))
+	assert.False(t, first.equal(second))
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "hosts")
+
+	w := newPollWatcher(fn, 10*time.Millisecond)
+	defer w.stop()
+
+	next := func() string {
+		select {
+		case change := <-w.changes():
+			return change
+		case <-time.After(time.Second):
+			return "timed out"
+		}
+	}
+
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.1 foo\n"), 0644))
+	assert.Equal(t, fn+": created", next())
+
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.1 foo bar\n"), 0644))
+	assert.Equal(t, fn+": modified", next())
+
+	assert.Nil(t, os.Remove(fn))
+	assert.Equal(t, fn+": removed", next())
+}
+
+func TestNotifyWatcherRelativePath(t *testing.T) {
+	defer func(watch string) { opts.Watch = watch }(opts.Watch)
+	opts.Watch = "notify"
+
+	dir, err := ioutil.TempDir("", "watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "real"), 0755))
+	assert.Nil(t, os.Symlink("real", filepath.Join(dir, "link")))
+
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+
+	// Relative, and through a symlink, while notify reports the real path
+	w := newWatcher("link/hosts")
+	defer w.stop()
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join("real", "hosts"), []byte("10.0.0.1 foo\n"), 0644))
+	select {
+	case <-w.changes():
+	case <-time.After(time.Second):
+		t.Error("no change seen for a relative path")
+	}
+}
+
+func TestNotifyWatcherStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "hosts")
+
+	w, err := newNotifyWatcher(fn)
+	assert.Nil(t, err)
+
+	// Events still in flight when the watch is stopped are dropped
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.1 foo\n"), 0644))
+	}
+	w.stop()
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("10.0.0.2 foo\n"), 0644))
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-w.changes():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("changes channel was not closed")
+		}
+	}
+}
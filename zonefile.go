@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// zoneFileClient syncs records to an RFC 1035 zone file on local disk, for
+// serving with nsd, BIND or similar.  Records that aren't managed by this
+// program are preserved, and the file is only rewritten when its contents
+// change.
+type zoneFileClient struct {
+	filename string
+	ns       string
+	ttl      int64
+}
+
+func newZoneFile() (*zoneFileClient, error) {
+	if opts.ZoneFile == "" {
+		return nil, fmt.Errorf("zone file must be specified for zonefile provider (--zone-file)")
+	}
+
+	return &zoneFileClient{
+		filename: opts.ZoneFile,
+		ns:       opts.ZoneFileNS,
+		ttl:      opts.TTL,
+	}, nil
+}
+
+// readZone parses the zone file.  If the file doesn't exist yet, a new SOA
+// record and no other records are returned.
+func (z *zoneFileClient) readZone(domain string) (*dns.SOA, []dns.RR, error) {
+	origin := dns.Fqdn(domain)
+
+	f, err := os.Open(z.filename)
+	if os.IsNotExist(err) {
+		return z.newSOA(origin), []dns.RR{}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var soa *dns.SOA
+	records := []dns.RR{}
+	zp := dns.NewZoneParser(f, origin, z.filename)
+	zp.SetDefaultTTL(uint32(z.ttl))
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if s, isSOA := rr.(*dns.SOA); isSOA {
+			soa = s
+			continue
+		}
+		records = append(records, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, nil, errors.Wrapf(err, "Cannot parse zone file %v", z.filename)
+	}
+
+	if soa == nil {
+		return nil, nil, fmt.Errorf("zone file %v has no SOA record", z.filename)
+	}
+
+	return soa, records, nil
+}
+
+// newSOA returns the SOA record used when creating a new zone file.
+func (z *zoneFileClient) newSOA(origin string) *dns.SOA {
+	ns := z.ns
+	if ns == "" {
+		ns = "ns1." + origin
+	}
+
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   origin,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(z.ttl),
+		},
+		Ns:      dns.Fqdn(ns),
+		Mbox:    "hostmaster." + origin,
+		Serial:  0,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  300,
+	}
+}
+
+func (z *zoneFileClient) getHosts(domain string) (hostList, error) {
+	_, records, err := z.readZone(domain)
+	if err != nil {
+		return hostList{}, err
+	}
+
+	return convertRRsToHosts(records), nil
+}
+
+func (z *zoneFileClient) sync(domain string, ttl int64, toUpdate hostList, toDelete hostList) error {
+	soa, records, err := z.readZone(domain)
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   soa.Hdr.Name,
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+				Ttl:    uint32(ttl),
+			},
+			Ns: soa.Ns,
+		})
+	}
+
+	updated := make([]dns.RR, 0, len(records)+len(toUpdate))
+	for _, rr := range records {
+		if !matchesAnyHost(rr, toUpdate, false) && !matchesAnyHost(rr, toDelete, true) {
+			updated = append(updated, rr)
+		}
+	}
+	for _, h := range toUpdate {
+		updated = append(updated, hostToRR(h, ttl))
+	}
+
+	if bytes.Equal(renderZone(soa, records, ttl), renderZone(soa, updated, ttl)) {
+		log.Info("Zone file contents unchanged, not rewriting ", z.filename)
+		return nil
+	}
+
+	soa.Serial = nextSerial(soa.Serial, time.Now())
+	return writeFileAtomic(z.filename, renderZone(soa, updated, ttl))
+}
+
+// wait is a no-op, since the zone file has been written by the time sync
+// returns.
+func (z *zoneFileClient) wait() error {
+	return nil
+}
+
+// matchesAnyHost reports whether rr is the record for one of hosts.  If
+// matchValue is false, any record of the right type for that name matches.
+func matchesAnyHost(rr dns.RR, hosts hostList, matchValue bool) bool {
+	rh, ok := rrToHost(rr)
+	if !ok {
+		return false
+	}
+
+	for _, h := range hosts {
+		if rh.key() != h.key() {
+			continue
+		}
+		if !matchValue || rh.value() == h.value() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextSerial returns the SOA serial to use after old.  Serials use the
+// conventional YYYYMMDDnn format when possible.
+func nextSerial(old uint32, now time.Time) uint32 {
+	date, _ := strconv.ParseUint(now.UTC().Format("20060102")+"00", 10, 32)
+	if uint32(date) > old {
+		return uint32(date)
+	}
+	return old + 1
+}
+
+// renderZone produces the zone file contents for the given SOA and records.
+// Records are sorted so that the same records always produce the same file.
+func renderZone(soa *dns.SOA, records []dns.RR, ttl int64) []byte {
+	sorted := make([]dns.RR, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Header(), sorted[j].Header()
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Rrtype != b.Rrtype {
+			return a.Rrtype < b.Rrtype
+		}
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; Address records managed by sync-hosts-to-route53\n")
+	fmt.Fprintf(&buf, "$ORIGIN %v\n", soa.Hdr.Name)
+	fmt.Fprintf(&buf, "$TTL %v\n", ttl)
+	fmt.Fprintln(&buf, soa.String())
+	for _, rr := range sorted {
+		fmt.Fprintln(&buf, rr.String())
+	}
+
+	return buf.Bytes()
+}
+
+// writeFileAtomic replaces filename with data, so that readers never see a
+// partially written file.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return errors.Wrap(err, "Cannot create temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Cannot write %v", f.Name())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Cannot write %v", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "Cannot write %v", f.Name())
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return errors.Wrapf(err, "Cannot set mode of %v", f.Name())
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2019, 7, 30, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, uint32(2019073000), nextSerial(0, now))
+	assert.Equal(t, uint32(2019073000), nextSerial(42, now))
+	assert.Equal(t, uint32(2019073001), nextSerial(2019073000, now))
+	assert.Equal(t, uint32(2019080100), nextSerial(2019080099, now))
+}
+
+func TestZoneFileSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zonefile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	z := &zoneFileClient{filename: filepath.Join(dir, "test.com.zone"), ttl: 3600}
+
+	hosts, err := z.getHosts("test.com")
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{}, hosts)
+
+	toUpdate := hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 300},
+		{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1"), ttl: 300},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.5"), ttl: 300},
+	}
+	assert.Nil(t, z.sync("test.com", 300, toUpdate, hostList{}))
+
+	data, err := ioutil.ReadFile(z.filename)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "$ORIGIN test.com.\n$TTL 300\n")
+	assert.Contains(t, string(data), "test.com.\t300\tIN\tNS\tns1.test.com.\n")
+
+	hosts, err = z.getHosts("test.com")
+	assert.Nil(t, err)
+	assert.Equal(t, toUpdate, hosts)
+
+	soa, _, err := z.readZone("test.com")
+	assert.Nil(t, err)
+	serial := soa.Serial
+
+	// Applying the same change again doesn't rewrite the file
+	assert.Nil(t, z.sync("test.com", 300, toUpdate[:1], hostList{}))
+	unchanged, err := ioutil.ReadFile(z.filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, unchanged)
+
+	// Hand added records are kept, changed hosts replaced and deleted
+	// hosts removed
+	f, err := os.OpenFile(z.filename, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString("mail 300 IN MX 10 test1\n")
+	f.Close()
+
+	assert.Nil(t, z.sync("test.com", 300,
+		hostList{{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6")}},
+		hostList{{hostname: "test1.test.com", ip: net.ParseIP("2001:db8::1")}}))
+
+	hosts, err = z.getHosts("test.com")
+	assert.Nil(t, err)
+	assert.Equal(t, hostList{
+		{hostname: "test1.test.com", ip: net.ParseIP("1.2.3.4"), ttl: 300},
+		{hostname: "test2.test.com", ip: net.ParseIP("1.2.3.6"), ttl: 300},
+	}, hosts)
+
+	soa, records, err := z.readZone("test.com")
+	assert.Nil(t, err)
+	assert.True(t, soa.Serial > serial)
+	found := false
+	for _, rr := range records {
+		if strings.Contains(rr.String(), "MX") {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}